@@ -20,26 +20,120 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
-// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+// SubjectKind identifies what the name of a Subject refers to.
+// +kubebuilder:validation:Enum=User;Group;Principal
+type SubjectKind string
+
+const (
+	// UserSubject refers to a Rancher User, either by object name (u-xxxxx) or by Username.
+	UserSubject SubjectKind = "User"
+	// GroupSubject refers to an SSO group by its principal ID, e.g. azuread_group://<object-id>.
+	GroupSubject SubjectKind = "Group"
+	// PrincipalSubject refers to a user principal ID, e.g. openldap_user://uid=jdoe,ou=people,dc=example,dc=com.
+	PrincipalSubject SubjectKind = "Principal"
+)
+
+// Subject is a user, group or principal that receives the assigned role templates.
+type Subject struct {
+	// Kind of the subject.
+	Kind SubjectKind `json:"kind"`
+
+	// Name of the subject. For users this is the User object name or its Username,
+	// for groups and principals the full principal ID.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
 
 // ClusterAssignmentSpec defines the desired state of ClusterAssignment
 type ClusterAssignmentSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Subjects receive every role template on every selected cluster.
+	// +kubebuilder:validation:MinItems=1
+	Subjects []Subject `json:"subjects"`
+
+	// ClusterSelector selects management.cattle.io Clusters by their labels. A cluster must satisfy the selector
+	// and every cluster filter below that is set.
+	// +optional
+	ClusterSelector *metav1.LabelSelector `json:"clusterSelector,omitempty"`
+
+	// ClusterNames selects management.cattle.io Clusters by name (e.g. c-m-abcd1234),
+	// in addition to the clusters matched by ClusterSelector and the filters below.
+	// +optional
+	ClusterNames []string `json:"clusterNames,omitempty"`
+
+	// ClusterDisplayName requires the display name of the cluster to match this glob pattern, where * matches
+	// any run of characters and ? a single character.
+	// +optional
+	ClusterDisplayName string `json:"clusterDisplayName,omitempty"`
+
+	// ClusterProviders requires the provider reported in the cluster status to be listed, e.g. rke2, k3s or aks.
+	// +optional
+	ClusterProviders []string `json:"clusterProviders,omitempty"`
+
+	// ClusterDrivers requires the driver reported in the cluster status to be listed, e.g. imported or AKS.
+	// +optional
+	ClusterDrivers []string `json:"clusterDrivers,omitempty"`
 
-	// Foo is an example field of ClusterAssignment. Edit clusterassignment_types.go to remove/update
-	Foo string `json:"foo,omitempty"`
+	// ClusterFleetWorkspaces requires the Fleet workspace of the cluster to be listed, e.g. fleet-default.
+	// +optional
+	ClusterFleetWorkspaces []string `json:"clusterFleetWorkspaces,omitempty"`
+
+	// RoleTemplateNames are the cluster role templates bound for each subject, e.g. cluster-owner or read-only.
+	// +kubebuilder:validation:MinItems=1
+	RoleTemplateNames []string `json:"roleTemplateNames"`
+}
+
+// Condition types reported on the status of the assignment resources.
+const (
+	// ConditionReady is True when every desired binding exists.
+	ConditionReady = "Ready"
+	// ConditionDegraded is True when some bindings could not be created, updated or deleted.
+	ConditionDegraded = "Degraded"
+	// ConditionInvalidSpec is True when the spec cannot be acted upon, e.g. because of a malformed selector.
+	ConditionInvalidSpec = "InvalidSpec"
+)
+
+// ClusterBindingStatus reports the bindings of a single cluster.
+type ClusterBindingStatus struct {
+	// ClusterName is the name of the management.cattle.io Cluster.
+	ClusterName string `json:"clusterName"`
+
+	// Bindings are the names of the ClusterRoleTemplateBindings in the cluster's namespace.
+	// +optional
+	Bindings []string `json:"bindings,omitempty"`
+
+	// Error is the last error met while applying the bindings of this cluster.
+	// +optional
+	Error string `json:"error,omitempty"`
 }
 
 // ClusterAssignmentStatus defines the observed state of ClusterAssignment
 type ClusterAssignmentStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ObservedGeneration is the generation of the spec the status was computed for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions are Ready, Degraded and InvalidSpec.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// MatchedClusters are the clusters currently selected by the assignment.
+	// +optional
+	MatchedClusters []string `json:"matchedClusters,omitempty"`
+
+	// Clusters reports the bindings and errors of every matched cluster.
+	// +optional
+	Clusters []ClusterBindingStatus `json:"clusters,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Degraded",type=string,JSONPath=`.status.conditions[?(@.type=="Degraded")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // ClusterAssignment is the Schema for the clusterassignments API
 type ClusterAssignment struct {
@@ -0,0 +1,151 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// UserSelector selects Rancher users by their attributes. A user is selected when it satisfies
+// Substring, if set, and every entry of Match. At least one of them must be set.
+type UserSelector struct {
+	// Substring selects the users whose Username contains it.
+	// +optional
+	Substring string `json:"substring,omitempty"`
+
+	// Match selects the users whose attributes satisfy every entry.
+	// +optional
+	Match []AttributeMatch `json:"match,omitempty"`
+}
+
+// MatchAttribute is the user attribute an AttributeMatch is evaluated against.
+// +kubebuilder:validation:Enum=Username;DisplayName;PrincipalID;GroupPrincipalID;GroupName;Label;Annotation
+type MatchAttribute string
+
+const (
+	UsernameAttribute    MatchAttribute = "Username"
+	DisplayNameAttribute MatchAttribute = "DisplayName"
+	// PrincipalIDAttribute matches when any of the user's principal IDs matches.
+	PrincipalIDAttribute MatchAttribute = "PrincipalID"
+	// GroupPrincipalIDAttribute matches when the ID of any of the user's SSO groups matches, e.g.
+	// azuread_group://0d8a... The groups are read from the user's UserAttribute.
+	GroupPrincipalIDAttribute MatchAttribute = "GroupPrincipalID"
+	// GroupNameAttribute matches when the display name of any of the user's SSO groups matches.
+	GroupNameAttribute  MatchAttribute = "GroupName"
+	LabelAttribute      MatchAttribute = "Label"
+	AnnotationAttribute MatchAttribute = "Annotation"
+)
+
+// MatchOperator is how an AttributeMatch compares the attribute with its value.
+// +kubebuilder:validation:Enum=Exact;Prefix;Substring;Glob;Regex
+type MatchOperator string
+
+const (
+	ExactOperator     MatchOperator = "Exact"
+	PrefixOperator    MatchOperator = "Prefix"
+	SubstringOperator MatchOperator = "Substring"
+	// GlobOperator supports * for any run of characters and ? for a single character.
+	GlobOperator MatchOperator = "Glob"
+	// RegexOperator uses the RE2 syntax. The expression must match the whole attribute.
+	RegexOperator MatchOperator = "Regex"
+)
+
+// AttributeMatch compares one attribute of a user with a value.
+type AttributeMatch struct {
+	// Attribute is the user attribute to compare.
+	Attribute MatchAttribute `json:"attribute"`
+
+	// Key is the label or annotation key, required for the Label and Annotation attributes. For the
+	// GroupPrincipalID and GroupName attributes it optionally restricts the groups to one auth provider,
+	// e.g. azuread or openldap.
+	// +optional
+	Key string `json:"key,omitempty"`
+
+	// Operator is how the attribute is compared with Value.
+	// +kubebuilder:default=Exact
+	// +optional
+	Operator MatchOperator `json:"operator,omitempty"`
+
+	// Value is compared with the attribute.
+	Value string `json:"value"`
+
+	// Negate selects the users that do not match, including users that lack the label or annotation.
+	// +optional
+	Negate bool `json:"negate,omitempty"`
+}
+
+// GlobalRoleAssignmentSpec defines the desired state of GlobalRoleAssignment
+type GlobalRoleAssignmentSpec struct {
+	// GlobalRoleName is the global role bound for each subject, e.g. user-base or restricted-admin.
+	// +kubebuilder:validation:MinLength=1
+	GlobalRoleName string `json:"globalRoleName"`
+
+	// Subjects receive the global role. Principal subjects must belong to an existing user.
+	// +optional
+	Subjects []Subject `json:"subjects,omitempty"`
+
+	// UserSelector grants the global role to every user it matches, in addition to Subjects.
+	// +optional
+	UserSelector *UserSelector `json:"userSelector,omitempty"`
+}
+
+// GlobalRoleAssignmentStatus defines the observed state of GlobalRoleAssignment
+type GlobalRoleAssignmentStatus struct {
+	// ObservedGeneration is the generation of the spec the status was computed for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions are Ready, Degraded and InvalidSpec.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Bindings are the names of the GlobalRoleBindings managed for the assignment.
+	// +optional
+	Bindings []string `json:"bindings,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Global Role",type=string,JSONPath=`.spec.globalRoleName`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Degraded",type=string,JSONPath=`.status.conditions[?(@.type=="Degraded")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// GlobalRoleAssignment is the Schema for the globalroleassignments API
+type GlobalRoleAssignment struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GlobalRoleAssignmentSpec   `json:"spec,omitempty"`
+	Status GlobalRoleAssignmentStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// GlobalRoleAssignmentList contains a list of GlobalRoleAssignment
+type GlobalRoleAssignmentList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []GlobalRoleAssignment `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&GlobalRoleAssignment{}, &GlobalRoleAssignmentList{})
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProjectReference selects a project by the cluster it belongs to and its display name.
+type ProjectReference struct {
+	// ClusterName is the name of the management.cattle.io Cluster, e.g. c-m-abcd1234.
+	// +kubebuilder:validation:MinLength=1
+	ClusterName string `json:"clusterName"`
+
+	// DisplayName is the name of the project as shown in the Rancher UI, e.g. Default.
+	// +kubebuilder:validation:MinLength=1
+	DisplayName string `json:"displayName"`
+}
+
+// ProjectAssignmentSpec defines the desired state of ProjectAssignment
+type ProjectAssignmentSpec struct {
+	// Subjects receive every role template in every selected project.
+	// +kubebuilder:validation:MinItems=1
+	Subjects []Subject `json:"subjects"`
+
+	// ProjectNames selects management.cattle.io Projects by name (p-xxxxx) or by
+	// project ID (c-m-abcd1234:p-xxxxx).
+	// +optional
+	ProjectNames []string `json:"projectNames,omitempty"`
+
+	// ProjectSelector selects management.cattle.io Projects by their labels.
+	// +optional
+	ProjectSelector *metav1.LabelSelector `json:"projectSelector,omitempty"`
+
+	// Projects selects projects by cluster and display name.
+	// +optional
+	Projects []ProjectReference `json:"projects,omitempty"`
+
+	// RoleTemplateNames are the project role templates bound for each subject, e.g. project-member or read-only.
+	// +kubebuilder:validation:MinItems=1
+	RoleTemplateNames []string `json:"roleTemplateNames"`
+}
+
+// ProjectBindingStatus reports the bindings of a single project.
+type ProjectBindingStatus struct {
+	// ProjectName is the project ID in the form <cluster>:<project>.
+	ProjectName string `json:"projectName"`
+
+	// Bindings are the names of the ProjectRoleTemplateBindings in the project's namespace.
+	// +optional
+	Bindings []string `json:"bindings,omitempty"`
+
+	// Error is the last error met while applying the bindings of this project.
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
+// ProjectAssignmentStatus defines the observed state of ProjectAssignment
+type ProjectAssignmentStatus struct {
+	// ObservedGeneration is the generation of the spec the status was computed for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions are Ready, Degraded and InvalidSpec.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// MatchedProjects are the IDs of the projects currently selected by the assignment.
+	// +optional
+	MatchedProjects []string `json:"matchedProjects,omitempty"`
+
+	// Projects reports the bindings and errors of every matched project.
+	// +optional
+	Projects []ProjectBindingStatus `json:"projects,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Degraded",type=string,JSONPath=`.status.conditions[?(@.type=="Degraded")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ProjectAssignment is the Schema for the projectassignments API
+type ProjectAssignment struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ProjectAssignmentSpec   `json:"spec,omitempty"`
+	Status ProjectAssignmentStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ProjectAssignmentList contains a list of ProjectAssignment
+type ProjectAssignmentList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ProjectAssignment `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ProjectAssignment{}, &ProjectAssignmentList{})
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BindingTarget is who the bindings of a rule are created for.
+// +kubebuilder:validation:Enum=User;Group
+type BindingTarget string
+
+const (
+	// UserTarget creates a binding for every selected user.
+	UserTarget BindingTarget = "User"
+	// GroupTarget creates a single binding for every selected SSO group and leaves membership to Rancher.
+	GroupTarget BindingTarget = "Group"
+)
+
+// OwnershipType is how a cluster records that a user owns it.
+// +kubebuilder:validation:Enum=Owner;OwnerGroup;Creator
+type OwnershipType string
+
+const (
+	// OwnerOwnership matches clusters whose owner key names the user, by Username or user ID.
+	OwnerOwnership OwnershipType = "Owner"
+	// OwnerGroupOwnership matches clusters whose owner key names one of the user's SSO groups, by group
+	// principal ID or display name.
+	OwnerGroupOwnership OwnershipType = "OwnerGroup"
+	// CreatorOwnership matches clusters the user created, according to their field.cattle.io/creatorId annotation.
+	CreatorOwnership OwnershipType = "Creator"
+)
+
+// ClusterOwnership is one way of finding the clusters a user owns.
+type ClusterOwnership struct {
+	// Type is how the cluster records its owners.
+	Type OwnershipType `json:"type"`
+
+	// Key is the label or annotation listing the owners, owner for the Owner type and owner-group for the
+	// OwnerGroup type by default. Its value names a single owner or, in an annotation, a comma-separated
+	// list of owners. More owners can be set with numbered keys, such as owner.1 and owner.2. Owners must
+	// match exactly. Key is not used by the Creator type.
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// ClusterScope selects the clusters a rule binds on. A cluster is selected when it is listed in Names, or when
+// it satisfies every other field that is set. Without any other field, only the listed clusters are selected.
+type ClusterScope struct {
+	// Names lists clusters by object name, e.g. c-m-abcd1234. Clusters that do not exist are skipped.
+	// +optional
+	Names []string `json:"names,omitempty"`
+
+	// Selector requires the labels of the cluster to match.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// DisplayName requires the display name of the cluster to match this glob pattern, where * matches any
+	// run of characters and ? a single character.
+	// +optional
+	DisplayName string `json:"displayName,omitempty"`
+
+	// Providers requires the provider reported in the cluster status to be listed, e.g. rke2, k3s or aks.
+	// +optional
+	Providers []string `json:"providers,omitempty"`
+
+	// Drivers requires the driver reported in the cluster status to be listed, e.g. imported or AKS.
+	// +optional
+	Drivers []string `json:"drivers,omitempty"`
+
+	// FleetWorkspaces requires the Fleet workspace of the cluster to be listed, e.g. fleet-default.
+	// +optional
+	FleetWorkspaces []string `json:"fleetWorkspaces,omitempty"`
+
+	// Ownership requires the user to own the cluster according to any of the entries. It is not supported
+	// with bindTo Group.
+	// +optional
+	Ownership []ClusterOwnership `json:"ownership,omitempty"`
+}
+
+// RoleMappingRule grants a cluster role template to the users it selects, on the clusters they own.
+type RoleMappingRule struct {
+	// Name identifies the rule. It is the suffix of the names of the bindings created for it.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+
+	// UserSelector selects the users the rule applies to.
+	UserSelector `json:",inline"`
+
+	// Expression is a CEL expression that must evaluate to true for the rule to bind a selected user on a
+	// candidate cluster. When set, substring and match may be left out. The variables are user.name,
+	// user.username, user.displayName, user.principalIds, user.labels, user.annotations, groups and
+	// groupNames (the IDs and display names of the user's group principals), and cluster.name,
+	// cluster.displayName, cluster.provider, cluster.driver, cluster.fleetWorkspaceName, cluster.labels
+	// and cluster.annotations. Example: "'platform' in groupNames && cluster.labels['env'] != 'prod'".
+	// +optional
+	Expression string `json:"expression,omitempty"`
+
+	// RoleTemplateName is the cluster role template bound for every selected user, e.g. cluster-owner.
+	// +kubebuilder:validation:MinLength=1
+	RoleTemplateName string `json:"roleTemplateName"`
+
+	// BindTo is User to bind every selected user, or Group to bind SSO groups instead. A Group rule selects
+	// the groups known from the users' UserAttributes with match entries on GroupPrincipalID or GroupName,
+	// and binds each of them once per cluster of Clusters, which it requires. Group rules are only
+	// supported in RoleMappings.
+	// +kubebuilder:default=User
+	// +optional
+	BindTo BindingTarget `json:"bindTo,omitempty"`
+
+	// Clusters selects the clusters the rule binds on. Without it, a User rule binds on the clusters whose
+	// owner label or annotation names the user, as if clusters.ownership held a single Owner entry.
+	// +optional
+	Clusters *ClusterScope `json:"clusters,omitempty"`
+}
+
+// RoleMappingSpec defines the desired state of RoleMapping
+type RoleMappingSpec struct {
+	// Rules map user attributes to cluster role templates.
+	// +kubebuilder:validation:MinItems=1
+	Rules []RoleMappingRule `json:"rules"`
+}
+
+// RoleMappingStatus defines the observed state of RoleMapping
+type RoleMappingStatus struct {
+	// ObservedGeneration is the generation of the spec the status was computed for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions are Ready, Degraded and InvalidSpec.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Errors lists the rules that are ignored because they are invalid, and the rules
+	// that refer to role templates that do not exist.
+	// +optional
+	Errors []string `json:"errors,omitempty"`
+
+	// GroupBindings lists the ClusterRoleTemplateBindings managed for the Group rules, as namespace/name.
+	// +optional
+	GroupBindings []string `json:"groupBindings,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// RoleMapping is the Schema for the rolemappings API
+type RoleMapping struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RoleMappingSpec   `json:"spec,omitempty"`
+	Status RoleMappingStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// RoleMappingList contains a list of RoleMapping
+type RoleMappingList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RoleMapping `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RoleMapping{}, &RoleMappingList{})
+}
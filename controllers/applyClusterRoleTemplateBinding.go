@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// applyClusterRoleTemplateBinding creates the binding unless a binding of the same name already grants the same.
+//
+// Rancher does not allow changing the subject, role template or cluster of an existing binding. When the
+// existing binding grants something else, it is replaced: the desired binding is created first, under a name
+// derived from what it grants, and the old binding is deleted only then, so that access does not drop in
+// between. A replacement whose old binding could not be deleted is finished on the next call. binding.Name is
+// set to the name the desired binding ends up with, and the replacement is recorded as an event on it.
+func applyClusterRoleTemplateBinding(ctx context.Context, c client.Client, recorder record.EventRecorder, binding *managementv3.ClusterRoleTemplateBinding) error {
+	name := binding.Name
+	replacementName := replacementBindingName(binding)
+
+	existingBinding := &managementv3.ClusterRoleTemplateBinding{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: binding.Namespace, Name: name}, existingBinding)
+	if apierrors.IsNotFound(err) {
+		// An earlier replacement may already hold the desired binding.
+		replacement := &managementv3.ClusterRoleTemplateBinding{}
+		err := c.Get(ctx, client.ObjectKey{Namespace: binding.Namespace, Name: replacementName}, replacement)
+		if err == nil && len(immutableFieldChanges(replacement, binding)) == 0 {
+			binding.Name = replacementName
+			return nil
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			globalLog.Error(err, "Failed to get ClusterRoleTemplateBinding", "Name", replacementName, "Namespace", binding.Namespace)
+			return err
+		}
+
+		if err := c.Create(ctx, binding); err != nil {
+			globalLog.Error(err, "Failed to create ClusterRoleTemplateBinding", "Name", name, "Namespace", binding.Namespace)
+			return err
+		}
+		globalLog.Info("Created ClusterRoleTemplateBinding", "Name", binding.Name, "Namespace", binding.Namespace)
+		return nil
+	}
+	if err != nil {
+		globalLog.Error(err, "Failed to get ClusterRoleTemplateBinding", "Name", name, "Namespace", binding.Namespace)
+		return err
+	}
+
+	if isUnmanaged(existingBinding) {
+		globalLog.V(1).Info("Leaving unmanaged ClusterRoleTemplateBinding alone", "Name", name, "Namespace", binding.Namespace)
+		return nil
+	}
+
+	changes := immutableFieldChanges(existingBinding, binding)
+	if len(changes) == 0 {
+		return restoreMetadata(ctx, c, existingBinding, binding)
+	}
+
+	binding.Name = replacementName
+	if err := c.Create(ctx, binding); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			globalLog.Error(err, "Failed to create replacement ClusterRoleTemplateBinding", "Name", binding.Name, "Namespace", binding.Namespace)
+			return err
+		}
+		// An earlier replacement got as far as creating the desired binding but failed to delete the old one.
+		replacement := &managementv3.ClusterRoleTemplateBinding{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(binding), replacement); err != nil {
+			globalLog.Error(err, "Failed to get replacement ClusterRoleTemplateBinding", "Name", binding.Name, "Namespace", binding.Namespace)
+			return err
+		}
+		if replacementChanges := immutableFieldChanges(replacement, binding); len(replacementChanges) > 0 {
+			err := fmt.Errorf("replacement ClusterRoleTemplateBinding %s/%s exists but grants something else: %s", binding.Namespace, binding.Name, strings.Join(replacementChanges, ", "))
+			globalLog.Error(err, "Failed to replace ClusterRoleTemplateBinding", "Name", name, "Namespace", binding.Namespace)
+			return err
+		}
+	}
+	if err := deleteClusterRoleTemplateBinding(ctx, c, existingBinding); err != nil {
+		globalLog.Error(err, "Failed to delete replaced ClusterRoleTemplateBinding", "Name", name, "Namespace", binding.Namespace)
+		return err
+	}
+
+	message := fmt.Sprintf("Replaced ClusterRoleTemplateBinding %s since %s cannot be updated", name, strings.Join(changes, ", "))
+	globalLog.Info(message, "Name", binding.Name, "Namespace", binding.Namespace)
+	if recorder != nil {
+		recorder.Event(binding, corev1.EventTypeNormal, "Replaced", message)
+	}
+	return nil
+}
+
+// restoreMetadata sets the managed labels and annotations of the existing binding back to the desired ones.
+func restoreMetadata(ctx context.Context, c client.Client, existing, desired *managementv3.ClusterRoleTemplateBinding) error {
+	if len(metadataChanges(existing, desired)) == 0 {
+		return nil
+	}
+	for _, key := range managedLabelKeys {
+		if value, ok := desired.Labels[key]; ok {
+			metav1.SetMetaDataLabel(&existing.ObjectMeta, key, value)
+		} else {
+			delete(existing.Labels, key)
+		}
+	}
+	for _, key := range managedAnnotations {
+		if value, ok := desired.Annotations[key]; ok {
+			metav1.SetMetaDataAnnotation(&existing.ObjectMeta, key, value)
+		} else {
+			delete(existing.Annotations, key)
+		}
+	}
+	if err := c.Update(ctx, existing); err != nil {
+		globalLog.Error(err, "Failed to restore labels and annotations of ClusterRoleTemplateBinding", "Name", existing.Name, "Namespace", existing.Namespace)
+		return err
+	}
+	ownUpdates.Store(client.ObjectKeyFromObject(existing), existing.ResourceVersion)
+	globalLog.Info("Restored labels and annotations of ClusterRoleTemplateBinding", "Name", existing.Name, "Namespace", existing.Namespace)
+	return nil
+}
+
+// immutableFieldChanges describes the fields Rancher does not allow to update that differ between the bindings.
+func immutableFieldChanges(existing, desired *managementv3.ClusterRoleTemplateBinding) []string {
+	return fieldChanges([]fieldChange{
+		{"roleTemplateName", existing.RoleTemplateName, desired.RoleTemplateName},
+		{"userName", existing.UserName, desired.UserName},
+		{"userPrincipalName", existing.UserPrincipalName, desired.UserPrincipalName},
+		{"groupPrincipalName", existing.GroupPrincipalName, desired.GroupPrincipalName},
+		{"clusterName", existing.ClusterName, desired.ClusterName},
+	})
+}
+
+// fieldChange is a field of a binding with its existing and desired values.
+type fieldChange struct {
+	name             string
+	existing, wanted string
+}
+
+// fieldChanges describes the fields whose values differ.
+func fieldChanges(fields []fieldChange) []string {
+	var changes []string
+	for _, field := range fields {
+		if field.existing != field.wanted {
+			changes = append(changes, fmt.Sprintf("%s changed from %q to %q", field.name, field.existing, field.wanted))
+		}
+	}
+	return changes
+}
+
+// replacementBindingName derives the name of a replacement binding from the binding's name and a hash of what
+// it grants, so that every change of the grant gets its own name.
+func replacementBindingName(binding *managementv3.ClusterRoleTemplateBinding) string {
+	return hashedBindingName(binding.Name, binding.RoleTemplateName, binding.UserName, binding.UserPrincipalName, binding.GroupPrincipalName, binding.ClusterName)
+}
+
+// hashedBindingName appends a hash of the parts to name.
+func hashedBindingName(name string, parts ...string) string {
+	h := fnv.New32a()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%s-%08x", name, h.Sum32())
+}
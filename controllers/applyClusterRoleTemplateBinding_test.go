@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+// newFakeClient returns a client serving objs from memory, with the field indexes the reconcilers look objects
+// up by.
+func newFakeClient(objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(managementv3.AddToScheme(scheme))
+	utilruntime.Must(permissionsv1alpha1.AddToScheme(scheme))
+	builder := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...)
+	utilruntime.Must(IndexFields(context.Background(), fakeIndexer{builder}))
+	return builder.Build()
+}
+
+// fakeIndexer registers field indexes with a fake client under construction.
+type fakeIndexer struct {
+	builder *fake.ClientBuilder
+}
+
+func (i fakeIndexer) IndexField(_ context.Context, obj client.Object, field string, extractValue client.IndexerFunc) error {
+	i.builder.WithIndex(obj, field, extractValue)
+	return nil
+}
+
+// failingDeletes fails every Delete, as an API server that is unreachable for deletions would.
+type failingDeletes struct {
+	client.Client
+}
+
+func (c failingDeletes) Delete(context.Context, client.Object, ...client.DeleteOption) error {
+	return errors.New("connection refused")
+}
+
+func TestApplyClusterRoleTemplateBindingReplacement(t *testing.T) {
+	ctx := context.Background()
+	desired := func() *managementv3.ClusterRoleTemplateBinding {
+		return &managementv3.ClusterRoleTemplateBinding{
+			ObjectMeta:       metav1.ObjectMeta{Name: "u-abc12-c-abc12-owners", Namespace: "c-abc12", Labels: managedLabels("u-abc12", "owners")},
+			RoleTemplateName: "cluster-owner",
+			UserName:         "u-abc12",
+			ClusterName:      "c-abc12",
+		}
+	}
+	old := desired()
+	old.RoleTemplateName = "cluster-member"
+	replacementName := replacementBindingName(desired())
+
+	exists := func(t *testing.T, c client.Client, name string) bool {
+		t.Helper()
+		err := c.Get(ctx, client.ObjectKey{Namespace: "c-abc12", Name: name}, &managementv3.ClusterRoleTemplateBinding{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			t.Fatalf("Get(%s) error = %v", name, err)
+		}
+		return err == nil
+	}
+
+	t.Run("first replacement", func(t *testing.T) {
+		c := newFakeClient(old.DeepCopy())
+		binding := desired()
+		if err := applyClusterRoleTemplateBinding(ctx, c, nil, binding); err != nil {
+			t.Fatalf("applyClusterRoleTemplateBinding() error = %v", err)
+		}
+		if binding.Name != replacementName {
+			t.Errorf("binding.Name = %s, want %s", binding.Name, replacementName)
+		}
+		if exists(t, c, old.Name) || !exists(t, c, replacementName) {
+			t.Errorf("old binding kept or replacement missing")
+		}
+	})
+
+	t.Run("retry after failed delete", func(t *testing.T) {
+		c := newFakeClient(old.DeepCopy())
+		if err := applyClusterRoleTemplateBinding(ctx, failingDeletes{c}, nil, desired()); err == nil {
+			t.Fatalf("applyClusterRoleTemplateBinding() succeeded although the old binding could not be deleted")
+		}
+		if !exists(t, c, old.Name) || !exists(t, c, replacementName) {
+			t.Fatalf("want both bindings after the failed delete")
+		}
+
+		binding := desired()
+		if err := applyClusterRoleTemplateBinding(ctx, c, nil, binding); err != nil {
+			t.Fatalf("retry: applyClusterRoleTemplateBinding() error = %v", err)
+		}
+		if binding.Name != replacementName {
+			t.Errorf("binding.Name = %s, want %s", binding.Name, replacementName)
+		}
+		if exists(t, c, old.Name) {
+			t.Errorf("old binding is still there after the retry")
+		}
+	})
+
+	t.Run("replacement already in place", func(t *testing.T) {
+		replacement := desired()
+		replacement.Name = replacementName
+		c := newFakeClient(replacement)
+		binding := desired()
+		if err := applyClusterRoleTemplateBinding(ctx, c, nil, binding); err != nil {
+			t.Fatalf("applyClusterRoleTemplateBinding() error = %v", err)
+		}
+		if binding.Name != replacementName {
+			t.Errorf("binding.Name = %s, want %s", binding.Name, replacementName)
+		}
+		if exists(t, c, desired().Name) {
+			t.Errorf("binding was created again under its original name")
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// applyGlobalRoleBinding creates the binding unless a binding of the same name already grants the same.
+//
+// Rancher does not allow changing the subject or global role of an existing binding either, so a binding that
+// grants something else is replaced the way applyClusterRoleTemplateBinding replaces one: the desired binding is
+// created first under its replacement name, and the old binding is deleted only then. binding.Name is set to
+// the name the desired binding ends up with.
+func applyGlobalRoleBinding(ctx context.Context, c client.Client, recorder record.EventRecorder, binding *managementv3.GlobalRoleBinding) error {
+	name := binding.Name
+	replacementName := replacementGlobalBindingName(binding)
+
+	existingBinding := &managementv3.GlobalRoleBinding{}
+	err := c.Get(ctx, client.ObjectKey{Name: name}, existingBinding)
+	if apierrors.IsNotFound(err) {
+		// An earlier replacement may already hold the desired binding.
+		replacement := &managementv3.GlobalRoleBinding{}
+		err := c.Get(ctx, client.ObjectKey{Name: replacementName}, replacement)
+		if err == nil && len(globalBindingImmutableFieldChanges(replacement, binding)) == 0 {
+			binding.Name = replacementName
+			return nil
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			globalLog.Error(err, "Failed to get GlobalRoleBinding", "Name", replacementName)
+			return err
+		}
+
+		if err := c.Create(ctx, binding); err != nil {
+			globalLog.Error(err, "Failed to create GlobalRoleBinding", "Name", name)
+			return err
+		}
+		globalLog.Info("Created GlobalRoleBinding", "Name", binding.Name)
+		return nil
+	}
+	if err != nil {
+		globalLog.Error(err, "Failed to get GlobalRoleBinding", "Name", name)
+		return err
+	}
+
+	if isUnmanaged(existingBinding) {
+		globalLog.V(1).Info("Leaving unmanaged GlobalRoleBinding alone", "Name", name)
+		return nil
+	}
+
+	changes := globalBindingImmutableFieldChanges(existingBinding, binding)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	binding.Name = replacementName
+	if err := c.Create(ctx, binding); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			globalLog.Error(err, "Failed to create replacement GlobalRoleBinding", "Name", binding.Name)
+			return err
+		}
+		// An earlier replacement got as far as creating the desired binding but failed to delete the old one.
+		replacement := &managementv3.GlobalRoleBinding{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(binding), replacement); err != nil {
+			globalLog.Error(err, "Failed to get replacement GlobalRoleBinding", "Name", binding.Name)
+			return err
+		}
+		if replacementChanges := globalBindingImmutableFieldChanges(replacement, binding); len(replacementChanges) > 0 {
+			err := fmt.Errorf("replacement GlobalRoleBinding %s exists but grants something else: %s", binding.Name, strings.Join(replacementChanges, ", "))
+			globalLog.Error(err, "Failed to replace GlobalRoleBinding", "Name", name)
+			return err
+		}
+	}
+	if err := c.Delete(ctx, existingBinding); err != nil && !apierrors.IsNotFound(err) {
+		globalLog.Error(err, "Failed to delete replaced GlobalRoleBinding", "Name", name)
+		return err
+	}
+
+	message := fmt.Sprintf("Replaced GlobalRoleBinding %s since %s cannot be updated", name, strings.Join(changes, ", "))
+	globalLog.Info(message, "Name", binding.Name)
+	if recorder != nil {
+		recorder.Event(binding, corev1.EventTypeNormal, "Replaced", message)
+	}
+	return nil
+}
+
+// globalBindingImmutableFieldChanges describes the fields Rancher does not allow to update that differ between
+// the bindings.
+func globalBindingImmutableFieldChanges(existing, desired *managementv3.GlobalRoleBinding) []string {
+	return fieldChanges([]fieldChange{
+		{"globalRoleName", existing.GlobalRoleName, desired.GlobalRoleName},
+		{"userName", existing.UserName, desired.UserName},
+		{"groupPrincipalName", existing.GroupPrincipalName, desired.GroupPrincipalName},
+	})
+}
+
+// replacementGlobalBindingName derives the name of a replacement binding, see replacementBindingName.
+func replacementGlobalBindingName(binding *managementv3.GlobalRoleBinding) string {
+	return hashedBindingName(binding.Name, binding.GlobalRoleName, binding.UserName, binding.GroupPrincipalName)
+}
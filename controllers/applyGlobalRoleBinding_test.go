@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestApplyGlobalRoleBindingReplacement(t *testing.T) {
+	ctx := context.Background()
+	desired := func() *managementv3.GlobalRoleBinding {
+		return &managementv3.GlobalRoleBinding{
+			ObjectMeta:     metav1.ObjectMeta{Name: "admins-1a2b3c4d", Labels: managedLabels("u-abc12", "")},
+			GlobalRoleName: "admin",
+			UserName:       "u-abc12",
+		}
+	}
+	exists := func(t *testing.T, c client.Client, name string) bool {
+		t.Helper()
+		err := c.Get(ctx, client.ObjectKey{Name: name}, &managementv3.GlobalRoleBinding{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			t.Fatalf("Get(%s) error = %v", name, err)
+		}
+		return err == nil
+	}
+
+	t.Run("unchanged", func(t *testing.T) {
+		c := newFakeClient(desired())
+		binding := desired()
+		if err := applyGlobalRoleBinding(ctx, c, nil, binding); err != nil {
+			t.Fatalf("applyGlobalRoleBinding() error = %v", err)
+		}
+		if binding.Name != desired().Name {
+			t.Errorf("binding.Name = %s, want %s", binding.Name, desired().Name)
+		}
+	})
+
+	t.Run("replacement", func(t *testing.T) {
+		old := desired()
+		old.GlobalRoleName = "user"
+		c := newFakeClient(old)
+		binding := desired()
+		if err := applyGlobalRoleBinding(ctx, c, nil, binding); err != nil {
+			t.Fatalf("applyGlobalRoleBinding() error = %v", err)
+		}
+		replacementName := replacementGlobalBindingName(desired())
+		if binding.Name != replacementName {
+			t.Errorf("binding.Name = %s, want %s", binding.Name, replacementName)
+		}
+		if exists(t, c, old.Name) || !exists(t, c, replacementName) {
+			t.Errorf("old binding kept or replacement missing")
+		}
+
+		// The replacement stands in for the desired binding from now on.
+		binding = desired()
+		if err := applyGlobalRoleBinding(ctx, c, nil, binding); err != nil {
+			t.Fatalf("applyGlobalRoleBinding() error = %v", err)
+		}
+		if binding.Name != replacementName || exists(t, c, desired().Name) {
+			t.Errorf("binding.Name = %s, want the replacement %s without creating the original again", binding.Name, replacementName)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// applyProjectRoleTemplateBinding creates the binding unless a binding of the same name already grants the same.
+//
+// Rancher does not allow changing the subject, role template or project of an existing binding either, so a
+// binding that grants something else is replaced the way applyClusterRoleTemplateBinding replaces one: the
+// desired binding is created first under its replacement name, and the old binding is deleted only then.
+// binding.Name is set to the name the desired binding ends up with.
+func applyProjectRoleTemplateBinding(ctx context.Context, c client.Client, recorder record.EventRecorder, binding *managementv3.ProjectRoleTemplateBinding) error {
+	name := binding.Name
+	replacementName := replacementProjectBindingName(binding)
+
+	existingBinding := &managementv3.ProjectRoleTemplateBinding{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: binding.Namespace, Name: name}, existingBinding)
+	if apierrors.IsNotFound(err) {
+		// An earlier replacement may already hold the desired binding.
+		replacement := &managementv3.ProjectRoleTemplateBinding{}
+		err := c.Get(ctx, client.ObjectKey{Namespace: binding.Namespace, Name: replacementName}, replacement)
+		if err == nil && len(projectBindingImmutableFieldChanges(replacement, binding)) == 0 {
+			binding.Name = replacementName
+			return nil
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			globalLog.Error(err, "Failed to get ProjectRoleTemplateBinding", "Name", replacementName, "Namespace", binding.Namespace)
+			return err
+		}
+
+		if err := c.Create(ctx, binding); err != nil {
+			globalLog.Error(err, "Failed to create ProjectRoleTemplateBinding", "Name", name, "Namespace", binding.Namespace)
+			return err
+		}
+		globalLog.Info("Created ProjectRoleTemplateBinding", "Name", binding.Name, "Namespace", binding.Namespace)
+		return nil
+	}
+	if err != nil {
+		globalLog.Error(err, "Failed to get ProjectRoleTemplateBinding", "Name", name, "Namespace", binding.Namespace)
+		return err
+	}
+
+	if isUnmanaged(existingBinding) {
+		globalLog.V(1).Info("Leaving unmanaged ProjectRoleTemplateBinding alone", "Name", name, "Namespace", binding.Namespace)
+		return nil
+	}
+
+	changes := projectBindingImmutableFieldChanges(existingBinding, binding)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	binding.Name = replacementName
+	if err := c.Create(ctx, binding); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			globalLog.Error(err, "Failed to create replacement ProjectRoleTemplateBinding", "Name", binding.Name, "Namespace", binding.Namespace)
+			return err
+		}
+		// An earlier replacement got as far as creating the desired binding but failed to delete the old one.
+		replacement := &managementv3.ProjectRoleTemplateBinding{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(binding), replacement); err != nil {
+			globalLog.Error(err, "Failed to get replacement ProjectRoleTemplateBinding", "Name", binding.Name, "Namespace", binding.Namespace)
+			return err
+		}
+		if replacementChanges := projectBindingImmutableFieldChanges(replacement, binding); len(replacementChanges) > 0 {
+			err := fmt.Errorf("replacement ProjectRoleTemplateBinding %s/%s exists but grants something else: %s", binding.Namespace, binding.Name, strings.Join(replacementChanges, ", "))
+			globalLog.Error(err, "Failed to replace ProjectRoleTemplateBinding", "Name", name, "Namespace", binding.Namespace)
+			return err
+		}
+	}
+	if err := c.Delete(ctx, existingBinding); err != nil && !apierrors.IsNotFound(err) {
+		globalLog.Error(err, "Failed to delete replaced ProjectRoleTemplateBinding", "Name", name, "Namespace", binding.Namespace)
+		return err
+	}
+
+	message := fmt.Sprintf("Replaced ProjectRoleTemplateBinding %s since %s cannot be updated", name, strings.Join(changes, ", "))
+	globalLog.Info(message, "Name", binding.Name, "Namespace", binding.Namespace)
+	if recorder != nil {
+		recorder.Event(binding, corev1.EventTypeNormal, "Replaced", message)
+	}
+	return nil
+}
+
+// projectBindingImmutableFieldChanges describes the fields Rancher does not allow to update that differ between
+// the bindings.
+func projectBindingImmutableFieldChanges(existing, desired *managementv3.ProjectRoleTemplateBinding) []string {
+	return fieldChanges([]fieldChange{
+		{"roleTemplateName", existing.RoleTemplateName, desired.RoleTemplateName},
+		{"userName", existing.UserName, desired.UserName},
+		{"userPrincipalName", existing.UserPrincipalName, desired.UserPrincipalName},
+		{"groupPrincipalName", existing.GroupPrincipalName, desired.GroupPrincipalName},
+		{"projectName", existing.ProjectName, desired.ProjectName},
+	})
+}
+
+// replacementProjectBindingName derives the name of a replacement binding, see replacementBindingName.
+func replacementProjectBindingName(binding *managementv3.ProjectRoleTemplateBinding) string {
+	return hashedBindingName(binding.Name, binding.RoleTemplateName, binding.UserName, binding.UserPrincipalName, binding.GroupPrincipalName, binding.ProjectName)
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestApplyProjectRoleTemplateBindingReplacement(t *testing.T) {
+	ctx := context.Background()
+	desired := func() *managementv3.ProjectRoleTemplateBinding {
+		return &managementv3.ProjectRoleTemplateBinding{
+			ObjectMeta:       metav1.ObjectMeta{Name: "platform-1a2b3c4d", Namespace: "p-xyz89", Labels: managedLabels("u-abc12", "")},
+			RoleTemplateName: "project-owner",
+			UserName:         "u-abc12",
+			ProjectName:      "c-abc12:p-xyz89",
+		}
+	}
+	old := desired()
+	old.RoleTemplateName = "project-member"
+	replacementName := replacementProjectBindingName(desired())
+
+	exists := func(t *testing.T, c client.Client, name string) bool {
+		t.Helper()
+		err := c.Get(ctx, client.ObjectKey{Namespace: "p-xyz89", Name: name}, &managementv3.ProjectRoleTemplateBinding{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			t.Fatalf("Get(%s) error = %v", name, err)
+		}
+		return err == nil
+	}
+
+	t.Run("first replacement", func(t *testing.T) {
+		c := newFakeClient(old.DeepCopy())
+		recorder := record.NewFakeRecorder(1)
+		binding := desired()
+		if err := applyProjectRoleTemplateBinding(ctx, c, recorder, binding); err != nil {
+			t.Fatalf("applyProjectRoleTemplateBinding() error = %v", err)
+		}
+		if binding.Name != replacementName {
+			t.Errorf("binding.Name = %s, want %s", binding.Name, replacementName)
+		}
+		if exists(t, c, old.Name) || !exists(t, c, replacementName) {
+			t.Errorf("old binding kept or replacement missing")
+		}
+		if len(recorder.Events) != 1 {
+			t.Errorf("recorded %d events, want the replacement", len(recorder.Events))
+		}
+	})
+
+	t.Run("retry after failed delete", func(t *testing.T) {
+		c := newFakeClient(old.DeepCopy())
+		if err := applyProjectRoleTemplateBinding(ctx, failingDeletes{c}, nil, desired()); err == nil {
+			t.Fatalf("applyProjectRoleTemplateBinding() succeeded although the old binding could not be deleted")
+		}
+		if !exists(t, c, old.Name) || !exists(t, c, replacementName) {
+			t.Fatalf("want both bindings after the failed delete")
+		}
+
+		binding := desired()
+		if err := applyProjectRoleTemplateBinding(ctx, c, nil, binding); err != nil {
+			t.Fatalf("retry: applyProjectRoleTemplateBinding() error = %v", err)
+		}
+		if binding.Name != replacementName || exists(t, c, old.Name) {
+			t.Errorf("binding.Name = %s, old binding kept = %v, want %s and none", binding.Name, exists(t, c, old.Name), replacementName)
+		}
+	})
+
+	t.Run("unmanaged binding", func(t *testing.T) {
+		unmanaged := old.DeepCopy()
+		unmanaged.Annotations = map[string]string{unmanagedAnnotation: "true"}
+		c := newFakeClient(unmanaged)
+		if err := applyProjectRoleTemplateBinding(ctx, c, nil, desired()); err != nil {
+			t.Fatalf("applyProjectRoleTemplateBinding() error = %v", err)
+		}
+		if !exists(t, c, old.Name) || exists(t, c, replacementName) {
+			t.Errorf("unmanaged binding was replaced")
+		}
+	})
+}
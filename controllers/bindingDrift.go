@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// unmanagedAnnotation, set to "true", hands a binding the operator created over to a human: the operator stops
+// correcting, replacing, revoking and deleting it.
+const unmanagedAnnotation = "permissions.xddevelopment.com/unmanaged"
+
+// managedLabelKeys and managedAnnotations are the labels and annotations the operator sets on its bindings and
+// restores when they are changed. The legacy annotation is never desired, restoring removes it.
+var (
+	managedLabelKeys   = []string{managedByLabel, userLabel, ruleLabel}
+	managedAnnotations = []string{roleMappingAnnotation, clusterAssignmentAnnotation, legacyCreatedByAnnotation}
+)
+
+func isUnmanaged(obj client.Object) bool {
+	return obj.GetAnnotations()[unmanagedAnnotation] == "true"
+}
+
+// ownDeletions holds the keys of the bindings the operator is deleting itself, and ownUpdates the resource
+// versions its own updates produced, so that neither is taken for drift when observed.
+var ownDeletions, ownUpdates sync.Map
+
+// deleteClusterRoleTemplateBinding deletes a binding the operator manages. A binding that is gone already is
+// not an error.
+func deleteClusterRoleTemplateBinding(ctx context.Context, c client.Client, binding *managementv3.ClusterRoleTemplateBinding) error {
+	key := client.ObjectKeyFromObject(binding)
+	ownDeletions.Store(key, true)
+	if err := c.Delete(ctx, binding); err != nil {
+		ownDeletions.Delete(key)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// bindingOwner returns the name of the object a reconciler created a binding for, or "" for the bindings of
+// other reconcilers.
+type bindingOwner func(*managementv3.ClusterRoleTemplateBinding) string
+
+// enqueueBindingOwner requeues the object a binding was created for.
+func enqueueBindingOwner(owner bindingOwner) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+		binding, ok := obj.(*managementv3.ClusterRoleTemplateBinding)
+		if !ok || owner(binding) == "" {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: owner(binding)}}}
+	})
+}
+
+// bindingDrift passes the events of the managed bindings of owner that someone other than the operator caused:
+// deletions, and changes to the fields and annotations the operator sets. Each of them is counted and recorded
+// as an event, the reconciler requeued by the event then recreates or reverts the binding.
+func bindingDrift(owner bindingOwner, recorder record.EventRecorder) predicate.Funcs {
+	return predicate.Funcs{
+		// Creation is never drift, and the initial list would requeue every owner.
+		CreateFunc: func(event.CreateEvent) bool { return false },
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			binding, ok := e.Object.(*managementv3.ClusterRoleTemplateBinding)
+			if !ok || !isManaged(binding) || owner(binding) == "" {
+				return false
+			}
+			if _, own := ownDeletions.LoadAndDelete(client.ObjectKeyFromObject(binding)); own {
+				return false
+			}
+			recordDrift(recorder, binding, "deleted", "ClusterRoleTemplateBinding was deleted outside of the operator, recreating it")
+			return true
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldBinding, ok := e.ObjectOld.(*managementv3.ClusterRoleTemplateBinding)
+			if !ok {
+				return false
+			}
+			newBinding, ok := e.ObjectNew.(*managementv3.ClusterRoleTemplateBinding)
+			if !ok || !isManaged(oldBinding) || isUnmanaged(newBinding) || owner(oldBinding) == "" {
+				return false
+			}
+			if version, own := ownUpdates.Load(client.ObjectKeyFromObject(newBinding)); own && version == newBinding.ResourceVersion {
+				ownUpdates.Delete(client.ObjectKeyFromObject(newBinding))
+				return false
+			}
+			changes := append(immutableFieldChanges(oldBinding, newBinding), metadataChanges(oldBinding, newBinding)...)
+			if len(changes) == 0 {
+				return false
+			}
+			recordDrift(recorder, newBinding, "modified", fmt.Sprintf("ClusterRoleTemplateBinding was modified outside of the operator, reverting it: %s", strings.Join(changes, ", ")))
+			return true
+		},
+		GenericFunc: func(event.GenericEvent) bool { return false },
+	}
+}
+
+// metadataChanges describes the managed labels and annotations that differ between the bindings.
+func metadataChanges(existing, desired *managementv3.ClusterRoleTemplateBinding) []string {
+	var changes []string
+	for _, key := range managedLabelKeys {
+		if existing.Labels[key] != desired.Labels[key] {
+			changes = append(changes, fmt.Sprintf("label %s changed from %q to %q", key, existing.Labels[key], desired.Labels[key]))
+		}
+	}
+	for _, key := range managedAnnotations {
+		if existing.Annotations[key] != desired.Annotations[key] {
+			changes = append(changes, fmt.Sprintf("annotation %s changed from %q to %q", key, existing.Annotations[key], desired.Annotations[key]))
+		}
+	}
+	return changes
+}
+
+func recordDrift(recorder record.EventRecorder, binding *managementv3.ClusterRoleTemplateBinding, change, message string) {
+	bindingDriftTotal.WithLabelValues(change).Inc()
+	globalLog.Info(message, "Name", binding.Name, "Namespace", binding.Namespace)
+	if recorder != nil {
+		recorder.Event(binding, corev1.EventTypeWarning, "Drift", message)
+	}
+}
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestBindingDrift(t *testing.T) {
+	labels := managedLabels("u-abc12", "owners")
+	binding := func(name string, annotations map[string]string) *managementv3.ClusterRoleTemplateBinding {
+		return &managementv3.ClusterRoleTemplateBinding{
+			ObjectMeta:       metav1.ObjectMeta{Name: name, Namespace: "c-abc12", Labels: labels, Annotations: annotations, ResourceVersion: "2"},
+			UserName:         "u-abc12",
+			RoleTemplateName: "cluster-owner",
+			ClusterName:      "c-abc12",
+		}
+	}
+	managed := map[string]string{roleMappingAnnotation: "default"}
+	drift := bindingDrift(userRuleBindingOwner, nil)
+
+	t.Run("deletions", func(t *testing.T) {
+		foreign := binding("foreign", nil)
+		foreign.Labels = nil
+		tests := []struct {
+			name    string
+			binding *managementv3.ClusterRoleTemplateBinding
+			own     bool
+			want    bool
+		}{
+			{name: "manual deletion", binding: binding("manual", managed), want: true},
+			{name: "own deletion", binding: binding("own", managed), own: true, want: false},
+			{name: "unmanaged binding", binding: binding("unmanaged", map[string]string{roleMappingAnnotation: "default", unmanagedAnnotation: "true"}), want: false},
+			{name: "foreign binding", binding: foreign, want: false},
+			{name: "other owner", binding: binding("assignment", map[string]string{clusterAssignmentAnnotation: "platform"}), want: false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if tt.own {
+					ownDeletions.Store(client.ObjectKeyFromObject(tt.binding), true)
+				}
+				if got := drift.Delete(event.DeleteEvent{Object: tt.binding}); got != tt.want {
+					t.Errorf("Delete() = %v, want %v", got, tt.want)
+				}
+				if _, left := ownDeletions.Load(client.ObjectKeyFromObject(tt.binding)); left {
+					t.Errorf("own deletion of %s was not consumed", tt.binding.Name)
+				}
+			})
+		}
+	})
+
+	t.Run("updates", func(t *testing.T) {
+		changedRole := binding("b", managed)
+		changedRole.RoleTemplateName = "cluster-member"
+		relabelled := binding("b", managed)
+		relabelled.Labels = managedLabels("u-abc12", "admins")
+		tests := []struct {
+			name       string
+			newBinding *managementv3.ClusterRoleTemplateBinding
+			own        bool
+			want       bool
+		}{
+			{name: "unrelated change", newBinding: binding("b", map[string]string{roleMappingAnnotation: "default", "note": "x"}), want: false},
+			{name: "role template changed", newBinding: changedRole, want: true},
+			{name: "rule label changed", newBinding: relabelled, want: true},
+			{name: "annotation removed", newBinding: binding("b", map[string]string{}), want: true},
+			{name: "own update", newBinding: binding("b", map[string]string{}), own: true, want: false},
+			{name: "taken over", newBinding: binding("b", map[string]string{roleMappingAnnotation: "default", unmanagedAnnotation: "true"}), want: false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if tt.own {
+					ownUpdates.Store(client.ObjectKeyFromObject(tt.newBinding), tt.newBinding.ResourceVersion)
+				}
+				if got := drift.Update(event.UpdateEvent{ObjectOld: binding("b", managed), ObjectNew: tt.newBinding}); got != tt.want {
+					t.Errorf("Update() = %v, want %v", got, tt.want)
+				}
+			})
+		}
+	})
+}
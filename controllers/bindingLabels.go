@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// managedByLabel marks the bindings the operator manages, so that they can be listed with a label selector
+	// and through the managed-by index.
+	managedByLabel      = "permissions.xddevelopment.com/managed-by"
+	managedByLabelValue = "rancher-operator-permissions"
+	// userLabel records the user, by object name, a binding was created for.
+	userLabel = "permissions.xddevelopment.com/user"
+	// ruleLabel records the role mapping rule a binding was created for.
+	ruleLabel = "permissions.xddevelopment.com/rule"
+
+	// legacyCreatedByAnnotation marked the managed bindings before managedByLabel. Bindings still carrying it
+	// are relabelled on startup.
+	legacyCreatedByAnnotation      = "created-by-pod"
+	legacyCreatedByAnnotationValue = "rancher-operator-permissions-controller-manager"
+)
+
+// managedBindings selects the bindings the operator manages, through the managed-by index of the cache.
+var managedBindings = client.MatchingFields{bindingManagedByField: managedByLabelValue}
+
+// managedBindingLabels selects the bindings the operator manages by label, for reads that bypass the cache.
+var managedBindingLabels = client.MatchingLabels{managedByLabel: managedByLabelValue}
+
+// managedLabels returns the labels of a binding created for the named user and by the named rule. Either name
+// may be empty, e.g. for group bindings and the bindings of assignments.
+func managedLabels(userName, ruleName string) map[string]string {
+	labels := map[string]string{managedByLabel: managedByLabelValue}
+	if userName != "" {
+		labels[userLabel] = userName
+	}
+	if ruleName != "" {
+		labels[ruleLabel] = ruleName
+	}
+	return labels
+}
+
+// userBindings selects the bindings the operator manages for the named user, through the user name index of
+// the cache.
+func userBindings(userName string) *client.ListOptions {
+	return &client.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector(bindingUserNameField, userName),
+		LabelSelector: labels.SelectorFromSet(labels.Set{managedByLabel: managedByLabelValue}),
+	}
+}
+
+// isManaged reports whether the operator created the binding and still manages it.
+func isManaged(obj client.Object) bool {
+	return obj.GetLabels()[managedByLabel] == managedByLabelValue && !isUnmanaged(obj)
+}
@@ -2,170 +2,293 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
 	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"reflect"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	logf "sigs.k8s.io/controller-runtime/pkg/log"
-	"strings"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
 )
 
-var globalLog = logf.Log
+const (
+	// clusterAssignmentAnnotation records the ClusterAssignment a binding was created for.
+	clusterAssignmentAnnotation = "permissions.xddevelopment.com/clusterassignment"
+	// clusterAssignmentFinalizer keeps a ClusterAssignment around until its bindings are deleted.
+	clusterAssignmentFinalizer = "permissions.xddevelopment.com/clusterassignment"
+)
 
 // ClusterAssignmentReconciler reconciles a ClusterAssignment object
 type ClusterAssignmentReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Recorder records the replacement of bindings whose immutable fields changed.
+	Recorder record.EventRecorder
+	// PrincipalProviders lists the auth providers, most preferred first, whose principal ID goes into the
+	// bindings of users with several.
+	PrincipalProviders []string
 }
 
 //+kubebuilder:rbac:groups=permissions.xddevelopment.com,resources=clusterassignments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=permissions.xddevelopment.com,resources=clusterassignments/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=permissions.xddevelopment.com,resources=clusterassignments/finalizers,verbs=update
-//+kubebuilder:rbac:groups=management.cattle.io,resources=users,verbs=get;list;watch;update;patch
-//+kubebuilder:rbac:groups=management.cattle.io,resources=clusters,verbs=get;list;watch;update;patch
-//+kubebuilder:rbac:groups=management.cattle.io,resources=clusterroletemplatebindings,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machines,verbs=*
-// +kubebuilder:rbac:groups=management.cattle.io,resources=clusters,verbs=*
-// +kubebuilder:rbac:groups=management.cattle.io,resources=projects,verbs=update
-// +kubebuilder:rbac:groups=provisioning.cattle.io,resources=clusters,verbs=*
-// +kubebuilder:rbac:groups=rke-machine-config.cattle.io,resources=*,verbs=*
-// +kubebuilder:rbac:groups=rke-machine.cattle.io,resources=*,verbs=*
-// +kubebuilder:rbac:groups=rke.cattle.io,resources=etcdsnapshots,verbs=get;list;watch
-// +kubebuilder:rbac:groups=*,resources=*,verbs=*
-// +kubebuilder:rbac:urls=*,verbs=*
 
+// Reconcile turns a ClusterAssignment into one ClusterRoleTemplateBinding per selected cluster, subject and
+// role template, and deletes the bindings it created earlier that are no longer part of that set.
 func (r *ClusterAssignmentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	// Fetch the User instance
-	user := &managementv3.User{}
-	err := r.Get(ctx, req.NamespacedName, user)
-	if err != nil {
-		if apierrors.IsNotFound(err) {
-			// The user has been deleted. Nothing left to do.
-			return ctrl.Result{}, nil
-		}
-		// handle error
+	assignment := &permissionsv1alpha1.ClusterAssignment{}
+	if err := r.Get(ctx, req.NamespacedName, assignment); err != nil {
+		// The assignment has been deleted and its bindings cleaned up by the finalizer.
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	// Check if the user is being deleted
-	if user.DeletionTimestamp != nil {
-		// The user is being deleted
-		return r.deleteUserBindings(ctx, user.Username)
+	if assignment.DeletionTimestamp != nil {
+		if err := r.pruneAssignmentBindings(ctx, assignment.Name, nil); err != nil {
+			return ctrl.Result{}, err
+		}
+		if controllerutil.RemoveFinalizer(assignment, clusterAssignmentFinalizer) {
+			if err := r.Update(ctx, assignment); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
 	}
 
-	// Default roleTemplates
-	roleTemplates := []struct {
-		substring    string
-		roleTemplate string
-	}{
-		{"cluster-admin", "cluster-admin"},
-		{"cluster-auditor", "read-only"},
-		{"developer", "projects-create"},
+	if controllerutil.AddFinalizer(assignment, clusterAssignmentFinalizer) {
+		if err := r.Update(ctx, assignment); err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 
-	// Try to load from an external file, e.g., "roleTemplates.json"
-	data, err := readFileIfExists("/config/roleTemplates.json")
-	if err == nil {
-		externalRoleTemplates, err := parseRoleTemplatesFile(data)
-		if err == nil && len(externalRoleTemplates) > 0 {
-			roleTemplates = externalRoleTemplates
-		} else {
-			globalLog.Error(err, "Failed to parse role templates file. Using defaults.")
-		}
-	} else {
-		globalLog.Info("No external role templates file found. Using defaults.")
+	status := permissionsv1alpha1.ClusterAssignmentStatus{
+		ObservedGeneration: assignment.Generation,
+		Conditions:         append([]metav1.Condition(nil), assignment.Status.Conditions...),
+	}
+	scope, err := validateClusterAssignment(assignment)
+	if err != nil {
+		globalLog.Info("ClusterAssignment has an invalid spec", "clusterAssignment", assignment.Name, "error", err.Error())
+		setStatusConditions(&status.Conditions, assignment.Generation, err, nil)
+		return ctrl.Result{}, r.updateStatus(ctx, assignment, status)
 	}
 
-	// Check the user's attributes or groups to decide which clusters they should have access to.
-	clusters, err := determineClustersForUser(ctx, r, user)
+	clusters, err := selectClusters(ctx, r.Client, scope)
 	if err != nil {
-		globalLog.Error(err, "Failed to retrieve list of clusters for user", "user", user.Name)
+		globalLog.Error(err, "Failed to select clusters for ClusterAssignment", "clusterAssignment", assignment.Name)
 		return ctrl.Result{}, err
 	}
-	if len(user.PrincipalIDs) == 0 {
-		globalLog.V(1).Info("User does not have yet any PrincipalIDs", "user", user.Name)
-		return ctrl.Result{}, nil
+	status.MatchedClusters = clusters
+
+	var failures []string
+	subjects, unresolved, err := resolveSubjects(ctx, r.Client, assignment.Spec.Subjects, r.PrincipalProviders)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	failures = append(failures, unresolved...)
+	// Role templates Rancher would not bind get no new bindings, but keep those created before.
+	roleTemplateProblems, roleTemplateFailures, err := checkAssignmentRoleTemplates(ctx, r.Client, assignment.Spec.RoleTemplateNames, clusterRoleContext)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
-	for _, rt := range roleTemplates {
-		if contains(user.Username, rt.substring) {
-			for clusterName := range clusters {
-				// Define a ClusterRoleTemplateBinding for each cluster the user should have access to.
-				bindingName := user.Name + "-" + clusterName + "-" + rt.substring
+	failures = append(failures, roleTemplateFailures...)
+
+	// Each cluster is applied on its own so that a failing cluster namespace does not block the others.
+	desired := map[client.ObjectKey]bool{}
+	for _, clusterName := range clusters {
+		clusterStatus := permissionsv1alpha1.ClusterBindingStatus{ClusterName: clusterName}
+		for i, subject := range assignment.Spec.Subjects {
+			if subjects[i] == nil {
+				continue
+			}
+			for j, roleTemplateName := range assignment.Spec.RoleTemplateNames {
 				binding := &managementv3.ClusterRoleTemplateBinding{
 					ObjectMeta: metav1.ObjectMeta{
-						Name:      bindingName,
+						Name:      assignmentBindingName(assignment.Name, clusterName, subject, roleTemplateName),
 						Namespace: clusterName,
+						Labels:    managedLabels(subjects[i].UserName, ""),
 						Annotations: map[string]string{
-							"created-by-pod": "rancher-operator-permissions-controller-manager",
+							clusterAssignmentAnnotation: assignment.Name,
 						},
 					},
-					RoleTemplateName:  rt.roleTemplate,
-					UserName:          user.Name,
-					UserPrincipalName: user.PrincipalIDs[0],
-					ClusterName:       clusterName,
+					UserName:           subjects[i].UserName,
+					UserPrincipalName:  subjects[i].UserPrincipalName,
+					GroupPrincipalName: subjects[i].GroupPrincipalName,
+					ClusterName:        clusterName,
+					RoleTemplateName:   roleTemplateName,
 				}
 
-				// Try to create the ClusterRoleTemplateBinding
-				if err := r.Create(ctx, binding); err != nil {
-					if apierrors.IsAlreadyExists(err) {
-						// If it already exists, then update it
-						existingBinding := &managementv3.ClusterRoleTemplateBinding{}
-						err := r.Get(ctx, client.ObjectKey{Namespace: binding.Namespace, Name: binding.Name}, existingBinding)
-						if err != nil {
-							// Log error
-							globalLog.Error(err, "Failed to get ClusterRoleTemplateBinding for update")
-							return ctrl.Result{}, err
-						}
-
-						// Check if it needs to be updated
-						if !reflect.DeepEqual(existingBinding.RoleTemplateName, binding.RoleTemplateName) ||
-							!reflect.DeepEqual(existingBinding.UserName, binding.UserName) ||
-							!reflect.DeepEqual(existingBinding.UserPrincipalName, binding.UserPrincipalName) ||
-							!reflect.DeepEqual(existingBinding.ClusterName, binding.ClusterName) {
-
-							existingBinding.RoleTemplateName = binding.RoleTemplateName
-							existingBinding.UserName = binding.UserName
-							existingBinding.UserPrincipalName = binding.UserPrincipalName
-							existingBinding.ClusterName = binding.ClusterName
-
-							if err := r.Update(ctx, existingBinding); err != nil {
-								// Log error
-								globalLog.Error(err, "Failed to update ClusterRoleTemplateBinding")
-								return ctrl.Result{}, err
-							}
-
-							// Log success of update
-							globalLog.Info("Updated ClusterRoleTemplateBinding", "Name", binding.Name, "Namespace", binding.Namespace)
-						}
-					} else {
-						// Handle other errors from the create operation
-						globalLog.Error(err, "Failed to create ClusterRoleTemplateBinding")
-						return ctrl.Result{}, err
-					}
-				} else {
-					// Log success of creation
-					globalLog.Info("Created ClusterRoleTemplateBinding", "Name", binding.Name, "Namespace", binding.Namespace)
+				// Keep failed bindings out of pruning, an existing binding must survive a failed update.
+				desired[client.ObjectKeyFromObject(binding)] = true
+				if roleTemplateProblems[j] != "" {
+					continue
 				}
-
+				if err := applyClusterRoleTemplateBinding(ctx, r.Client, r.Recorder, binding); err != nil {
+					clusterStatus.Error = err.Error()
+					continue
+				}
+				// The binding may have been replaced under another name.
+				desired[client.ObjectKeyFromObject(binding)] = true
+				clusterStatus.Bindings = append(clusterStatus.Bindings, binding.Name)
 			}
-		} else {
-			globalLog.V(1).Info("Failed to find role name in field user.Username", "user.Username", user.Username)
 		}
+		if clusterStatus.Error != "" {
+			message := fmt.Sprintf("cluster %s: %s", clusterName, clusterStatus.Error)
+			failures = append(failures, message)
+			if r.Recorder != nil {
+				r.Recorder.Event(assignment, corev1.EventTypeWarning, "BindingFailed", message)
+			}
+		}
+		status.Clusters = append(status.Clusters, clusterStatus)
+	}
+
+	if err := r.pruneAssignmentBindings(ctx, assignment.Name, desired); err != nil {
+		failures = append(failures, err.Error())
+	}
+
+	setStatusConditions(&status.Conditions, assignment.Generation, nil, failures)
+	if err := r.updateStatus(ctx, assignment, status); err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(failures) > 0 {
+		return ctrl.Result{}, fmt.Errorf("ClusterAssignment %s is degraded: %d failure(s)", assignment.Name, len(failures))
 	}
 	return ctrl.Result{}, nil
 }
 
+// updateStatus writes status to the assignment unless it is unchanged.
+func (r *ClusterAssignmentReconciler) updateStatus(ctx context.Context, assignment *permissionsv1alpha1.ClusterAssignment, status permissionsv1alpha1.ClusterAssignmentStatus) error {
+	if equality.Semantic.DeepEqual(assignment.Status, status) {
+		return nil
+	}
+	assignment.Status = status
+	if err := r.Status().Update(ctx, assignment); err != nil {
+		globalLog.Error(err, "Failed to update ClusterAssignment status", "clusterAssignment", assignment.Name)
+		return err
+	}
+	return nil
+}
+
+// validateClusterAssignment reports the spec errors that the CRD schema cannot catch. For a valid spec, it returns
+// the clusters the assignment selects, compiled.
+func validateClusterAssignment(assignment *permissionsv1alpha1.ClusterAssignment) (*clusterScope, error) {
+	scope := clusterAssignmentScope(&assignment.Spec)
+	if len(scope.Names) == 0 && !hasClusterCriteria(scope) {
+		return nil, fmt.Errorf("one of clusterSelector, clusterNames or a cluster filter is required")
+	}
+	if assignment.Spec.ClusterSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(assignment.Spec.ClusterSelector); err != nil {
+			return nil, fmt.Errorf("invalid clusterSelector: %w", err)
+		}
+	}
+	compiled, problems := compileClusterScope(scope, permissionsv1alpha1.UserTarget)
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid cluster filter: %s", strings.Join(problems, "; "))
+	}
+	return compiled, nil
+}
+
+// pruneAssignmentBindings deletes the bindings created for the named assignment that are not in keep.
+func (r *ClusterAssignmentReconciler) pruneAssignmentBindings(ctx context.Context, assignmentName string, keep map[client.ObjectKey]bool) error {
+	var bindingList managementv3.ClusterRoleTemplateBindingList
+	if err := r.List(ctx, &bindingList, managedBindings); err != nil {
+		return err
+	}
+
+	for i := range bindingList.Items {
+		binding := &bindingList.Items[i]
+		if binding.Annotations[clusterAssignmentAnnotation] != assignmentName || isUnmanaged(binding) || keep[client.ObjectKeyFromObject(binding)] {
+			continue
+		}
+		if err := deleteClusterRoleTemplateBinding(ctx, r.Client, binding); err != nil {
+			globalLog.Error(err, "Failed to delete ClusterRoleTemplateBinding", "name", binding.Name, "namespace", binding.Namespace)
+			return err
+		}
+		globalLog.Info("Deleted ClusterRoleTemplateBinding no longer covered by ClusterAssignment", "name", binding.Name, "namespace", binding.Namespace, "clusterAssignment", assignmentName)
+	}
+	return nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *ClusterAssignmentReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&managementv3.User{}).
+		For(&permissionsv1alpha1.ClusterAssignment{}).
+		// Rancher updates the status of clusters all the time, only changes that can select them matter.
+		Watches(&source.Kind{Type: &managementv3.Cluster{}}, handler.EnqueueRequestsFromMapFunc(r.allClusterAssignments), builder.WithPredicates(clusterSelectionChanged)).
+		// Disabled users lose their bindings, re-enabled ones get them back.
+		Watches(&source.Kind{Type: &managementv3.User{}}, handler.EnqueueRequestsFromMapFunc(r.allClusterAssignments), builder.WithPredicates(userEnabledChanged)).
+		Watches(&source.Kind{Type: &managementv3.RoleTemplate{}}, handler.EnqueueRequestsFromMapFunc(r.allClusterAssignments), builder.WithPredicates(roleTemplateValidityChanged)).
+		Watches(&source.Kind{Type: &managementv3.ClusterRoleTemplateBinding{}}, enqueueBindingOwner(clusterAssignmentBindingOwner), builder.WithPredicates(bindingDrift(clusterAssignmentBindingOwner, r.Recorder))).
 		Complete(r)
 }
 
-// Helper function to check if a string contains a substring.
-func contains(s, substr string) bool {
-	return strings.Contains(s, substr)
+// clusterAssignmentBindingOwner returns the ClusterAssignment a binding was created for.
+func clusterAssignmentBindingOwner(binding *managementv3.ClusterRoleTemplateBinding) string {
+	return binding.Annotations[clusterAssignmentAnnotation]
+}
+
+// allClusterAssignments requeues every ClusterAssignment, since any of them may select a new or relabelled cluster,
+// name a user that was disabled or re-enabled, or a role template that changed.
+func (r *ClusterAssignmentReconciler) allClusterAssignments(_ client.Object) []reconcile.Request {
+	var assignmentList permissionsv1alpha1.ClusterAssignmentList
+	if err := r.List(context.Background(), &assignmentList); err != nil {
+		globalLog.Error(err, "Failed to list ClusterAssignments")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(assignmentList.Items))
+	for _, assignment := range assignmentList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&assignment)})
+	}
+	return requests
+}
+
+// clusterAssignmentScope describes the clusters the assignment selects the way rules describe theirs.
+func clusterAssignmentScope(spec *permissionsv1alpha1.ClusterAssignmentSpec) *permissionsv1alpha1.ClusterScope {
+	return &permissionsv1alpha1.ClusterScope{
+		Names:           spec.ClusterNames,
+		Selector:        spec.ClusterSelector,
+		DisplayName:     spec.ClusterDisplayName,
+		Providers:       spec.ClusterProviders,
+		Drivers:         spec.ClusterDrivers,
+		FleetWorkspaces: spec.ClusterFleetWorkspaces,
+	}
+}
+
+// selectClusters returns the names of the clusters the scope selects, matched as for the rules of a RoleMapping.
+// The scope of an assignment selects clusters without regard to any user.
+func selectClusters(ctx context.Context, c client.Client, scope *clusterScope) ([]string, error) {
+	var clusterList managementv3.ClusterList
+	if err := c.List(ctx, &clusterList); err != nil {
+		return nil, err
+	}
+
+	var clusters []string
+	for i := range clusterList.Items {
+		if _, ok := clusterInScope(&clusterList.Items[i], scope, &managementv3.User{}, nil); ok {
+			clusters = append(clusters, clusterList.Items[i].Name)
+		}
+	}
+	return clusters, nil
+}
+
+// assignmentBindingName derives a stable binding name from the assignment and a hash of what the binding grants,
+// since principal IDs are not valid in object names. scope is the cluster or project the binding applies to.
+func assignmentBindingName(assignmentName, scope string, subject permissionsv1alpha1.Subject, roleTemplateName string) string {
+	h := fnv.New32a()
+	for _, part := range []string{scope, string(subject.Kind), subject.Name, roleTemplateName} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%s-%08x", assignmentName, h.Sum32())
 }
@@ -0,0 +1,203 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+func TestSelectClusters(t *testing.T) {
+	cluster := func(name, displayName, provider, workspace string, labels map[string]string) *managementv3.Cluster {
+		c := &managementv3.Cluster{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+		c.Spec.DisplayName = displayName
+		c.Spec.FleetWorkspaceName = workspace
+		c.Status.Provider = provider
+		return c
+	}
+	c := newFakeClient(
+		cluster("c-abc12", "prod-eu", "rke2", "fleet-default", map[string]string{"env": "prod"}),
+		cluster("c-def34", "prod-us", "aks", "fleet-default", map[string]string{"env": "prod"}),
+		cluster("c-ghi56", "dev-eu", "rke2", "fleet-dev", map[string]string{"env": "dev"}),
+	)
+	tests := []struct {
+		name string
+		spec permissionsv1alpha1.ClusterAssignmentSpec
+		want []string
+	}{
+		{
+			name: "label selector",
+			spec: permissionsv1alpha1.ClusterAssignmentSpec{ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}},
+			want: []string{"c-abc12", "c-def34"},
+		},
+		{
+			name: "label selector and provider",
+			spec: permissionsv1alpha1.ClusterAssignmentSpec{ClusterSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}, ClusterProviders: []string{"rke2"}},
+			want: []string{"c-abc12"},
+		},
+		{
+			name: "display name and names",
+			spec: permissionsv1alpha1.ClusterAssignmentSpec{ClusterDisplayName: "*-us", ClusterNames: []string{"c-ghi56"}},
+			want: []string{"c-def34", "c-ghi56"},
+		},
+		{
+			name: "fleet workspace",
+			spec: permissionsv1alpha1.ClusterAssignmentSpec{ClusterFleetWorkspaces: []string{"fleet-dev"}},
+			want: []string{"c-ghi56"},
+		},
+		{
+			name: "names only",
+			spec: permissionsv1alpha1.ClusterAssignmentSpec{ClusterNames: []string{"c-abc12", "c-missing"}},
+			want: []string{"c-abc12"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, problems := compileClusterScope(clusterAssignmentScope(&tt.spec), permissionsv1alpha1.UserTarget)
+			if len(problems) > 0 {
+				t.Fatalf("compileClusterScope() problems = %v", problems)
+			}
+			got, err := selectClusters(context.Background(), c, scope)
+			if err != nil {
+				t.Fatalf("selectClusters() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectClusters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClusterAssignmentReconcileFailingCluster(t *testing.T) {
+	ctx := context.Background()
+	assignment := &permissionsv1alpha1.ClusterAssignment{
+		ObjectMeta: metav1.ObjectMeta{Name: "platform"},
+		Spec: permissionsv1alpha1.ClusterAssignmentSpec{
+			Subjects:          []permissionsv1alpha1.Subject{{Kind: permissionsv1alpha1.GroupSubject, Name: "azuread_group://1"}},
+			ClusterNames:      []string{"c-abc12", "c-bad99", "c-def34"},
+			RoleTemplateNames: []string{"cluster-member"},
+		},
+	}
+	c := newFakeClient(
+		assignment,
+		&managementv3.RoleTemplate{ObjectMeta: metav1.ObjectMeta{Name: "cluster-member"}, Context: clusterRoleContext},
+		&managementv3.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "c-abc12"}},
+		&managementv3.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "c-bad99"}},
+		&managementv3.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "c-def34"}},
+	)
+	recorder := record.NewFakeRecorder(10)
+	r := &ClusterAssignmentReconciler{Client: failingNamespace{Client: c, namespace: "c-bad99"}, Recorder: recorder}
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(assignment)})
+	if err == nil || !strings.Contains(err.Error(), "1 failure(s)") {
+		t.Errorf("Reconcile() error = %v, want the failed cluster", err)
+	}
+
+	if got := clusterBindingNamespaces(t, c); !got["c-abc12"] || !got["c-def34"] || got["c-bad99"] {
+		t.Errorf("bindings in %v, want c-abc12 and c-def34 only", got)
+	}
+	events := drainEvents(recorder)
+	if len(events) != 1 || !strings.Contains(events[0], "BindingFailed cluster c-bad99: ") {
+		t.Errorf("events = %v, want one BindingFailed event for c-bad99", events)
+	}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(assignment), assignment); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(assignment.Status.Clusters) != 3 {
+		t.Errorf("status lists %d clusters, want 3", len(assignment.Status.Clusters))
+	}
+	for _, cluster := range assignment.Status.Clusters {
+		if failed := cluster.Error != ""; failed != (cluster.ClusterName == "c-bad99") {
+			t.Errorf("status of cluster %s = %+v, want an error on c-bad99 only", cluster.ClusterName, cluster)
+		}
+	}
+}
+
+var _ = Describe("ClusterAssignment controller", func() {
+	ctx := context.Background()
+	assignmentStatus := func(name string) func() permissionsv1alpha1.ClusterAssignmentStatus {
+		return func() permissionsv1alpha1.ClusterAssignmentStatus {
+			assignment := &permissionsv1alpha1.ClusterAssignment{}
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Name: name}, assignment)).To(Succeed())
+			return assignment.Status
+		}
+	}
+	conditionStatus := func(conditionType string) func(permissionsv1alpha1.ClusterAssignmentStatus) metav1.ConditionStatus {
+		return func(status permissionsv1alpha1.ClusterAssignmentStatus) metav1.ConditionStatus {
+			return conditionOf(status.Conditions, conditionType).Status
+		}
+	}
+
+	It("binds the subjects on the selected clusters and reports Ready", func() {
+		createCluster(ctx, "c-envca1")
+		createRoleTemplate(ctx, "envtest-ca-member", clusterRoleContext, false)
+		Expect(k8sClient.Create(ctx, &permissionsv1alpha1.ClusterAssignment{
+			ObjectMeta: metav1.ObjectMeta{Name: "envtest-bound"},
+			Spec: permissionsv1alpha1.ClusterAssignmentSpec{
+				Subjects:          []permissionsv1alpha1.Subject{{Kind: permissionsv1alpha1.GroupSubject, Name: "azuread_group://envtest-ca"}},
+				ClusterNames:      []string{"c-envca1"},
+				RoleTemplateNames: []string{"envtest-ca-member"},
+			},
+		})).To(Succeed())
+
+		Eventually(func() []managementv3.ClusterRoleTemplateBinding {
+			return clusterBindingsFor(ctx, "c-envca1", clusterAssignmentAnnotation, "envtest-bound")
+		}, envtestTimeout, envtestInterval).Should(ConsistOf(And(
+			HaveField("GroupPrincipalName", "azuread_group://envtest-ca"),
+			HaveField("RoleTemplateName", "envtest-ca-member"),
+			HaveField("ClusterName", "c-envca1"),
+		)))
+		Eventually(assignmentStatus("envtest-bound"), envtestTimeout, envtestInterval).Should(And(
+			WithTransform(conditionStatus(permissionsv1alpha1.ConditionReady), Equal(metav1.ConditionTrue)),
+			HaveField("MatchedClusters", ConsistOf("c-envca1")),
+		))
+	})
+
+	It("reports a spec without clusters as invalid", func() {
+		Expect(k8sClient.Create(ctx, &permissionsv1alpha1.ClusterAssignment{
+			ObjectMeta: metav1.ObjectMeta{Name: "envtest-invalid"},
+			Spec: permissionsv1alpha1.ClusterAssignmentSpec{
+				Subjects:          []permissionsv1alpha1.Subject{{Kind: permissionsv1alpha1.GroupSubject, Name: "azuread_group://envtest-ca"}},
+				RoleTemplateNames: []string{"envtest-ca-member"},
+			},
+		})).To(Succeed())
+
+		Eventually(assignmentStatus("envtest-invalid"), envtestTimeout, envtestInterval).Should(And(
+			WithTransform(conditionStatus(permissionsv1alpha1.ConditionInvalidSpec), Equal(metav1.ConditionTrue)),
+			WithTransform(conditionStatus(permissionsv1alpha1.ConditionReady), Equal(metav1.ConditionFalse)),
+		))
+	})
+
+	It("creates no bindings of a role template Rancher would not bind and reports Degraded", func() {
+		createCluster(ctx, "c-envca2")
+		createRoleTemplate(ctx, "envtest-ca-locked", clusterRoleContext, true)
+		Expect(k8sClient.Create(ctx, &permissionsv1alpha1.ClusterAssignment{
+			ObjectMeta: metav1.ObjectMeta{Name: "envtest-locked"},
+			Spec: permissionsv1alpha1.ClusterAssignmentSpec{
+				Subjects:          []permissionsv1alpha1.Subject{{Kind: permissionsv1alpha1.GroupSubject, Name: "azuread_group://envtest-ca"}},
+				ClusterNames:      []string{"c-envca2"},
+				RoleTemplateNames: []string{"envtest-ca-locked"},
+			},
+		})).To(Succeed())
+
+		Eventually(func() metav1.Condition {
+			return conditionOf(assignmentStatus("envtest-locked")().Conditions, permissionsv1alpha1.ConditionDegraded)
+		}, envtestTimeout, envtestInterval).Should(And(
+			HaveField("Status", metav1.ConditionTrue),
+			HaveField("Message", ContainSubstring(`role template "envtest-ca-locked" is locked`)),
+		))
+		Consistently(func() []managementv3.ClusterRoleTemplateBinding {
+			return clusterBindingsFor(ctx, "c-envca2", clusterAssignmentAnnotation, "envtest-locked")
+		}, time.Second, envtestInterval).Should(BeEmpty())
+	})
+})
@@ -3,35 +3,33 @@ package controllers
 import (
 	"context"
 	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func (r *ClusterAssignmentReconciler) deleteUserBindings(ctx context.Context, Username string) (ctrl.Result, error) {
+// deleteUserBindings deletes the bindings the operator manages for the user with the given object name, which is
+// what Rancher bindings refer to in their UserName field.
+func (r *UserReconciler) deleteUserBindings(ctx context.Context, userName string) (ctrl.Result, error) {
 	var bindingList managementv3.ClusterRoleTemplateBindingList
 	globalLog.V(1).Info("Starting deleteUserBindings method...")
 
-	// List all ClusterRoleTemplateBindings
-	if err := r.List(ctx, &bindingList); err != nil {
+	// List the ClusterRoleTemplateBindings labelled for the user
+	if err := r.List(ctx, &bindingList, userBindings(userName)); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	// Filter out objects based on annotations and name
+	// Leave out the bindings a human took over
 	toDelete := []*managementv3.ClusterRoleTemplateBinding{}
-	for _, binding := range bindingList.Items {
-		globalLog.V(1).Info("Checking binding", "UserName", binding.UserName, "Annotations", binding.Annotations)
-
-		if binding.UserName == Username {
-			annotationValue, hasAnnotation := binding.Annotations["created-by-pod"]
-			if hasAnnotation && annotationValue == "rancher-operator-permissions-controller-manager" {
-				toDelete = append(toDelete, &binding)
-			} else if !hasAnnotation {
-				globalLog.V(1).Info("Binding has no 'created-by-pod' annotation", "BindingName", binding.Name)
-			} else {
-				globalLog.V(1).Info("Binding annotation doesn't match expected value", "BindingName", binding.Name, "AnnotationValue", annotationValue)
-			}
-		} else {
-			globalLog.V(1).Info("Binding UserName doesn't match the given UserName", "BindingUserName", binding.UserName, "GivenUserName", Username)
+	for i := range bindingList.Items {
+		binding := &bindingList.Items[i]
+		if isUnmanaged(binding) {
+			globalLog.V(1).Info("Binding was handed over with the unmanaged annotation", "BindingName", binding.Name)
+			continue
 		}
+		toDelete = append(toDelete, binding)
 	}
 
 	if len(toDelete) > 0 {
@@ -39,7 +37,7 @@ func (r *ClusterAssignmentReconciler) deleteUserBindings(ctx context.Context, Us
 	}
 
 	for _, binding := range toDelete {
-		if err := r.Delete(ctx, binding); err != nil {
+		if err := deleteClusterRoleTemplateBinding(ctx, r.Client, binding); err != nil {
 			globalLog.Info("Error deleting ClusterRoleTemplateBinding", "name", binding.Name, "namespace", binding.Namespace, "error", err)
 			return ctrl.Result{}, err
 		} else {
@@ -47,6 +45,68 @@ func (r *ClusterAssignmentReconciler) deleteUserBindings(ctx context.Context, Us
 		}
 	}
 
+	var projectBindingList managementv3.ProjectRoleTemplateBindingList
+	if err := r.List(ctx, &projectBindingList, userBindings(userName)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	for i := range projectBindingList.Items {
+		binding := &projectBindingList.Items[i]
+		if isUnmanaged(binding) {
+			continue
+		}
+		if err := r.Delete(ctx, binding); err != nil && !apierrors.IsNotFound(err) {
+			globalLog.Info("Error deleting ProjectRoleTemplateBinding", "name", binding.Name, "namespace", binding.Namespace, "error", err)
+			return ctrl.Result{}, err
+		}
+		globalLog.Info("Successfully deleted ProjectRoleTemplateBinding", "name", binding.Name, "namespace", binding.Namespace)
+	}
+
+	var globalBindingList managementv3.GlobalRoleBindingList
+	if err := r.List(ctx, &globalBindingList, userBindings(userName)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	for i := range globalBindingList.Items {
+		binding := &globalBindingList.Items[i]
+		if isUnmanaged(binding) {
+			continue
+		}
+		if err := r.Delete(ctx, binding); err != nil && !apierrors.IsNotFound(err) {
+			globalLog.Info("Error deleting GlobalRoleBinding", "name", binding.Name, "error", err)
+			return ctrl.Result{}, err
+		}
+		globalLog.Info("Successfully deleted GlobalRoleBinding", "name", binding.Name)
+	}
+
 	globalLog.V(1).Info("Exiting deleteUserBindings method...")
 	return ctrl.Result{}, nil
 }
+
+// hasManagedBindings reports whether bindings the operator manages are left for the user with the given object
+// name, so that the user keeps its finalizer until they are deleted.
+func (r *UserReconciler) hasManagedBindings(ctx context.Context, userName string) (bool, error) {
+	lists := []client.ObjectList{
+		&managementv3.ClusterRoleTemplateBindingList{},
+		&managementv3.ProjectRoleTemplateBindingList{},
+		&managementv3.GlobalRoleBindingList{},
+	}
+	for _, list := range lists {
+		if err := r.List(ctx, list, userBindings(userName)); err != nil {
+			return false, err
+		}
+		found := false
+		if err := meta.EachListItem(list, func(obj runtime.Object) error {
+			if binding, ok := obj.(client.Object); ok && !isUnmanaged(binding) {
+				found = true
+			}
+			return nil
+		}); err != nil {
+			return false, err
+		}
+		if found {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestHasManagedBindings(t *testing.T) {
+	unmanaged := &managementv3.ProjectRoleTemplateBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "unmanaged", Namespace: "p-abc12", Labels: managedLabels("u-abc12", "owners"), Annotations: map[string]string{unmanagedAnnotation: "true"}},
+		UserName:   "u-abc12",
+	}
+	global := &managementv3.GlobalRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "global", Labels: managedLabels("u-abc12", "admins")},
+		UserName:   "u-abc12",
+	}
+	for _, tc := range []struct {
+		name string
+		objs []client.Object
+		want bool
+	}{
+		{"no bindings", nil, false},
+		{"unmanaged binding only", []client.Object{unmanaged}, false},
+		{"managed global binding", []client.Object{unmanaged, global}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &UserReconciler{Client: newFakeClient(tc.objs...)}
+			got, err := r.hasManagedBindings(context.Background(), "u-abc12")
+			if err != nil {
+				t.Fatalf("hasManagedBindings() error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("hasManagedBindings() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -1,35 +1,237 @@
 package controllers
 
 import (
-	"context"
+	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 
 	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+const (
+	// defaultOwnerKey and defaultOwnerGroupKey are the labels or annotations listing the owners of a cluster.
+	defaultOwnerKey      = "owner"
+	defaultOwnerGroupKey = "owner-group"
+	// creatorIDAnnotation is set by Rancher to the ID of the user who created the cluster.
+	creatorIDAnnotation = "field.cattle.io/creatorId"
 )
 
-// TODO: This should be updated later according to SSO integration
-func determineClustersForUser(ctx context.Context, r *ClusterAssignmentReconciler, user *managementv3.User) (map[string]string, error) {
-	var clusterList managementv3.ClusterList
-	if err := r.List(ctx, &clusterList); err != nil {
-		return nil, err
-	}
-
-	clusters := make(map[string]string)
-	username := user.Username
-	for _, cluster := range clusterList.Items {
-		labels := cluster.ObjectMeta.GetLabels()
-		if ownerLabel, ok := labels["owner"]; ok {
-			// Check all substrings of length 5
-			for i := 0; i <= len(username)-5; i++ {
-				substr := username[i : i+5]
-				if strings.Contains(ownerLabel, substr) {
-					clusters[cluster.Name] = cluster.Namespace
-					globalLog.V(1).Info("Matching cluster found", "cluster", cluster.Name, "display name ", cluster.Spec.DisplayName)
-					break
+// defaultClusterScope is used by User rules without clusters.
+var defaultClusterScope = &clusterScope{ClusterScope: &permissionsv1alpha1.ClusterScope{
+	Ownership: []permissionsv1alpha1.ClusterOwnership{{Type: permissionsv1alpha1.OwnerOwnership}},
+}}
+
+// clusterScope is a ClusterScope with its label selector and display name pattern compiled, so that matching it
+// against every cluster does not parse them again.
+type clusterScope struct {
+	*permissionsv1alpha1.ClusterScope
+	// selector and displayName are nil when the scope does not set them.
+	selector    labels.Selector
+	displayName *regexp.Regexp
+}
+
+// determineClustersForUser returns the clusters of the scope for the user, a member of groups, along with the
+// reason each of them was selected.
+func determineClustersForUser(clusters []managementv3.Cluster, scope *clusterScope, user *managementv3.User, groups []managementv3.Principal) map[string]string {
+	if scope == nil {
+		scope = defaultClusterScope
+	}
+	selected := map[string]string{}
+	for i := range clusters {
+		if reason, ok := clusterInScope(&clusters[i], scope, user, groups); ok {
+			selected[clusters[i].Name] = reason
+		}
+	}
+	return selected
+}
+
+// clusterInScope reports whether the scope selects the cluster for the user, and why.
+func clusterInScope(cluster *managementv3.Cluster, scope *clusterScope, user *managementv3.User, groups []managementv3.Principal) (string, bool) {
+	if isListed(scope.Names, cluster.Name) {
+		return "listed in clusters.names", true
+	}
+	if !hasClusterCriteria(scope.ClusterScope) {
+		return "", false
+	}
+
+	var reasons []string
+	if scope.Selector != nil {
+		if scope.selector == nil || !scope.selector.Matches(labels.Set(cluster.Labels)) {
+			return "", false
+		}
+		reasons = append(reasons, fmt.Sprintf("labels match %s", scope.selector))
+	}
+	if scope.DisplayName != "" {
+		if scope.displayName == nil || !scope.displayName.MatchString(cluster.Spec.DisplayName) {
+			return "", false
+		}
+		reasons = append(reasons, fmt.Sprintf("display name %s matches %s", cluster.Spec.DisplayName, scope.DisplayName))
+	}
+	for _, filter := range []struct {
+		name   string
+		listed []string
+		value  string
+	}{
+		{"provider", scope.Providers, cluster.Status.Provider},
+		{"driver", scope.Drivers, cluster.Status.Driver},
+		{"fleet workspace", scope.FleetWorkspaces, cluster.Spec.FleetWorkspaceName},
+	} {
+		if len(filter.listed) == 0 {
+			continue
+		}
+		if !isListed(filter.listed, filter.value) {
+			return "", false
+		}
+		reasons = append(reasons, fmt.Sprintf("%s is %s", filter.name, filter.value))
+	}
+	if len(scope.Ownership) > 0 {
+		owned := false
+		for _, ownership := range scope.Ownership {
+			if reason, ok := ownsCluster(cluster, ownership, user, groups); ok {
+				reasons = append(reasons, reason)
+				owned = true
+				break
+			}
+		}
+		if !owned {
+			return "", false
+		}
+	}
+	return strings.Join(reasons, ", "), true
+}
+
+// hasClusterCriteria reports whether the scope selects clusters by anything other than their names.
+func hasClusterCriteria(scope *permissionsv1alpha1.ClusterScope) bool {
+	return scope.Selector != nil || scope.DisplayName != "" || len(scope.Providers) > 0 || len(scope.Drivers) > 0 ||
+		len(scope.FleetWorkspaces) > 0 || len(scope.Ownership) > 0
+}
+
+func isListed(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ownsCluster reports whether the cluster records the user as an owner in the way ownership describes, and how.
+func ownsCluster(cluster *managementv3.Cluster, ownership permissionsv1alpha1.ClusterOwnership, user *managementv3.User, groups []managementv3.Principal) (string, bool) {
+	switch ownership.Type {
+	case permissionsv1alpha1.OwnerOwnership:
+		for _, owner := range clusterOwners(cluster, ownerKey(ownership, defaultOwnerKey)) {
+			if owner.value == user.Username || owner.value == user.Name {
+				return fmt.Sprintf("%s names user %s", owner.source, owner.value), true
+			}
+		}
+	case permissionsv1alpha1.OwnerGroupOwnership:
+		for _, owner := range clusterOwners(cluster, ownerKey(ownership, defaultOwnerGroupKey)) {
+			for _, group := range groups {
+				if owner.value == group.Name || owner.value == group.DisplayName {
+					return fmt.Sprintf("%s names group %s", owner.source, owner.value), true
 				}
 			}
 		}
+	case permissionsv1alpha1.CreatorOwnership:
+		if creator := cluster.Annotations[creatorIDAnnotation]; creator != "" && creator == user.Name {
+			return fmt.Sprintf("annotation %s is %s", creatorIDAnnotation, creator), true
+		}
 	}
+	return "", false
+}
+
+func ownerKey(ownership permissionsv1alpha1.ClusterOwnership, defaultKey string) string {
+	if ownership.Key != "" {
+		return ownership.Key
+	}
+	return defaultKey
+}
 
-	return clusters, nil
+// clusterOwner is an owner named by a cluster, along with the label or annotation naming it.
+type clusterOwner struct {
+	source string
+	value  string
+}
+
+// clusterOwners returns the owners the cluster lists under key and its numbered variants, key.1, key.2 and so
+// on, in labels and annotations. Each value is a single owner or a comma-separated list of owners.
+func clusterOwners(cluster *managementv3.Cluster, key string) []clusterOwner {
+	var owners []clusterOwner
+	for _, source := range []struct {
+		kind   string
+		values map[string]string
+	}{
+		{"label", cluster.Labels},
+		{"annotation", cluster.Annotations},
+	} {
+		keys := make([]string, 0, len(source.values))
+		for k := range source.values {
+			if k == key || isNumberedKey(k, key) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, value := range strings.Split(source.values[k], ",") {
+				if value = strings.TrimSpace(value); value != "" {
+					owners = append(owners, clusterOwner{source: source.kind + " " + k, value: value})
+				}
+			}
+		}
+	}
+	return owners
+}
+
+// isNumberedKey reports whether k is key followed by a dot and a number, such as owner.2.
+func isNumberedKey(k, key string) bool {
+	n := strings.TrimPrefix(k, key+".")
+	if n == k || n == "" {
+		return false
+	}
+	for _, r := range n {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// compileClusterScope compiles the clusters of a rule, returning the problems that the CRD schema cannot catch.
+// A nil scope compiles to nil.
+func compileClusterScope(scope *permissionsv1alpha1.ClusterScope, bindTo permissionsv1alpha1.BindingTarget) (*clusterScope, []string) {
+	if scope == nil {
+		return nil, nil
+	}
+	compiled := &clusterScope{ClusterScope: scope}
+	var problems []string
+	if scope.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(scope.Selector)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("clusters.selector: %s", err.Error()))
+		}
+		compiled.selector = selector
+	}
+	if scope.DisplayName != "" {
+		pattern, err := regexp.Compile(globToRegex(scope.DisplayName))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("clusters.displayName: %s", err.Error()))
+		}
+		compiled.displayName = pattern
+	}
+	for i, ownership := range scope.Ownership {
+		switch ownership.Type {
+		case permissionsv1alpha1.OwnerOwnership, permissionsv1alpha1.OwnerGroupOwnership, permissionsv1alpha1.CreatorOwnership:
+		default:
+			problems = append(problems, fmt.Sprintf("clusters.ownership[%d]: unknown type %q", i, ownership.Type))
+		}
+	}
+	if bindTo == permissionsv1alpha1.GroupTarget && len(scope.Ownership) > 0 {
+		problems = append(problems, "clusters.ownership is not supported with bindTo Group")
+	}
+	return compiled, problems
 }
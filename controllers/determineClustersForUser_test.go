@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+func TestDetermineClustersForUser(t *testing.T) {
+	user := &managementv3.User{ObjectMeta: metav1.ObjectMeta{Name: "u-abc12"}, Username: "jsmith"}
+	groups := []managementv3.Principal{
+		{ObjectMeta: metav1.ObjectMeta{Name: "azuread_group://5678"}, DisplayName: "platform"},
+	}
+	cluster := func(name string, labels, annotations map[string]string) managementv3.Cluster {
+		return managementv3.Cluster{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels, Annotations: annotations}}
+	}
+	clusters := []managementv3.Cluster{
+		cluster("c-exact", map[string]string{"owner": "jsmith"}, nil),
+		cluster("c-similar", map[string]string{"owner": "smithers"}, nil),
+		cluster("c-list", nil, map[string]string{"owner": "alice, jsmith"}),
+		cluster("c-numbered", map[string]string{"owner.1": "alice", "owner.2": "jsmith"}, nil),
+		cluster("c-not-numbered", map[string]string{"owner.x": "jsmith"}, nil),
+		cluster("c-group", nil, map[string]string{"owner-group": "platform"}),
+		cluster("c-created", nil, map[string]string{"field.cattle.io/creatorId": "u-abc12"}),
+		cluster("c-listed", nil, nil),
+	}
+	prod := cluster("c-prod", map[string]string{"env": "prod", "owner": "jsmith"}, nil)
+	prod.Spec.DisplayName = "prod-eu-1"
+	prod.Spec.FleetWorkspaceName = "fleet-default"
+	prod.Status.Provider = "rke2"
+	prod.Status.Driver = "imported"
+	clusters = append(clusters, prod)
+
+	tests := []struct {
+		name  string
+		scope *permissionsv1alpha1.ClusterScope
+		want  map[string]string
+	}{
+		{
+			name:  "default scope matches owners exactly",
+			scope: nil,
+			want: map[string]string{
+				"c-exact":    "label owner names user jsmith",
+				"c-list":     "annotation owner names user jsmith",
+				"c-numbered": "label owner.2 names user jsmith",
+				"c-prod":     "label owner names user jsmith",
+			},
+		},
+		{
+			name: "owner groups and creator",
+			scope: &permissionsv1alpha1.ClusterScope{Ownership: []permissionsv1alpha1.ClusterOwnership{
+				{Type: permissionsv1alpha1.OwnerGroupOwnership},
+				{Type: permissionsv1alpha1.CreatorOwnership},
+			}},
+			want: map[string]string{
+				"c-group":   "annotation owner-group names group platform",
+				"c-created": "annotation field.cattle.io/creatorId is u-abc12",
+			},
+		},
+		{
+			name: "selector and filters",
+			scope: &permissionsv1alpha1.ClusterScope{
+				Selector:        &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+				DisplayName:     "prod-*",
+				Providers:       []string{"rke2", "k3s"},
+				Drivers:         []string{"imported"},
+				FleetWorkspaces: []string{"fleet-default"},
+			},
+			want: map[string]string{
+				"c-prod": "labels match env=prod, display name prod-eu-1 matches prod-*, provider is rke2, driver is imported, fleet workspace is fleet-default",
+			},
+		},
+		{
+			name: "filters and ownership must all hold",
+			scope: &permissionsv1alpha1.ClusterScope{
+				Providers: []string{"k3s"},
+				Ownership: []permissionsv1alpha1.ClusterOwnership{{Type: permissionsv1alpha1.OwnerOwnership}},
+			},
+			want: map[string]string{},
+		},
+		{
+			name:  "names only",
+			scope: &permissionsv1alpha1.ClusterScope{Names: []string{"c-listed", "c-missing"}},
+			want:  map[string]string{"c-listed": "listed in clusters.names"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, problems := compileClusterScope(tt.scope, permissionsv1alpha1.UserTarget)
+			if len(problems) > 0 {
+				t.Fatalf("compileClusterScope() problems = %v", problems)
+			}
+			got := determineClustersForUser(clusters, scope, user, groups)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileClusterScope(t *testing.T) {
+	scope, problems := compileClusterScope(&permissionsv1alpha1.ClusterScope{
+		Selector:    &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+		DisplayName: "prod-*.eu?",
+	}, permissionsv1alpha1.UserTarget)
+	if len(problems) > 0 {
+		t.Fatalf("compileClusterScope() problems = %v", problems)
+	}
+	if scope.selector == nil || scope.selector.String() != "env=prod" {
+		t.Errorf("selector = %v, want env=prod", scope.selector)
+	}
+	for displayName, want := range map[string]bool{"prod-web.eu1": true, "prod-.eu2": true, "prod-web.eu": false, "dev-web.eu1": false} {
+		if got := scope.displayName.MatchString(displayName); got != want {
+			t.Errorf("displayName matches %q = %v, want %v", displayName, got, want)
+		}
+	}
+
+	_, problems = compileClusterScope(&permissionsv1alpha1.ClusterScope{
+		Selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "env", Operator: "Near"}}},
+	}, permissionsv1alpha1.UserTarget)
+	if len(problems) != 1 {
+		t.Errorf("compileClusterScope() problems = %v, want one for the selector", problems)
+	}
+
+	if scope, problems := compileClusterScope(nil, permissionsv1alpha1.UserTarget); scope != nil || len(problems) > 0 {
+		t.Errorf("compileClusterScope(nil) = %v, %v, want nil", scope, problems)
+	}
+}
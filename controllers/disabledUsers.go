@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"context"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// accessRevokedAnnotation marks a User whose bindings were revoked because it was disabled, so that re-enabling
+// it is recognized as such, across restarts of the operator too.
+const accessRevokedAnnotation = "permissions.xddevelopment.com/access-revoked"
+
+// auditLog records the changes of access that follow from changes to a user rather than to the rules.
+var auditLog = globalLog.WithName("audit")
+
+// isDisabled reports whether the user was disabled in Rancher. Users without the field are enabled.
+func isDisabled(user *managementv3.User) bool {
+	return user.Enabled != nil && !*user.Enabled
+}
+
+// revokeDisabledUser deletes every binding the operator manages for a disabled user, whichever rule or
+// assignment it was created for, and marks the user so that its bindings are restored once it is re-enabled.
+// The finalizer is no longer needed without bindings.
+func (r *UserReconciler) revokeDisabledUser(ctx context.Context, user *managementv3.User) error {
+	if _, err := r.deleteUserBindings(ctx, user.Name); err != nil {
+		return err
+	}
+
+	changed := controllerutil.RemoveFinalizer(user, userBindingsFinalizer)
+	revoked := user.Annotations[accessRevokedAnnotation] == "true"
+	if !revoked {
+		if user.Annotations == nil {
+			user.Annotations = map[string]string{}
+		}
+		user.Annotations[accessRevokedAnnotation] = "true"
+		changed = true
+	}
+	if changed {
+		if err := r.Update(ctx, user); err != nil {
+			return err
+		}
+	}
+
+	if !revoked {
+		auditLog.Info("Revoked access of disabled user", "user", user.Name, "username", user.Username)
+		if r.Recorder != nil {
+			r.Recorder.Event(user, corev1.EventTypeWarning, "AccessRevoked", "User is disabled, all bindings managed by the operator were revoked")
+		}
+	}
+	return nil
+}
+
+// clearAccessRevoked removes the mark of a user whose bindings were revoked while it was disabled. It reports
+// whether the user was marked, and thus needs to be updated.
+func clearAccessRevoked(user *managementv3.User) bool {
+	if _, revoked := user.Annotations[accessRevokedAnnotation]; !revoked {
+		return false
+	}
+	delete(user.Annotations, accessRevokedAnnotation)
+	return true
+}
+
+// recordAccessRestored records that the bindings of a re-enabled user were restored.
+func (r *UserReconciler) recordAccessRestored(user *managementv3.User) {
+	auditLog.Info("Restored access of re-enabled user", "user", user.Name, "username", user.Username)
+	if r.Recorder != nil {
+		r.Recorder.Event(user, corev1.EventTypeNormal, "AccessRestored", "User is enabled again, its bindings were restored")
+	}
+}
+
+// userEnabledChanged filters User events down to those that can change whether assignments bind the user:
+// creation, deletion, and updates that disable or re-enable it.
+var userEnabledChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldUser, ok := e.ObjectOld.(*managementv3.User)
+		if !ok {
+			return false
+		}
+		newUser, ok := e.ObjectNew.(*managementv3.User)
+		if !ok {
+			return false
+		}
+		return isDisabled(oldUser) != isDisabled(newUser)
+	},
+	GenericFunc: func(event.GenericEvent) bool { return false },
+}
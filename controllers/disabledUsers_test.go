@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestUserEnabledChanged(t *testing.T) {
+	enabled, disabled := true, false
+	user := func(value *bool) *managementv3.User {
+		return &managementv3.User{Username: "jsmith", Enabled: value}
+	}
+	tests := []struct {
+		name     string
+		old, new *bool
+		want     bool
+	}{
+		{name: "disabled", old: &enabled, new: &disabled, want: true},
+		{name: "re-enabled", old: &disabled, new: &enabled, want: true},
+		{name: "defaulted", old: nil, new: &enabled, want: false},
+		{name: "disabled without field", old: nil, new: &disabled, want: true},
+		{name: "unchanged", old: &disabled, new: &disabled, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := userEnabledChanged.Update(event.UpdateEvent{ObjectOld: user(tt.old), ObjectNew: user(tt.new)}); got != tt.want {
+				t.Errorf("Update() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClearAccessRevoked(t *testing.T) {
+	user := &managementv3.User{}
+	if clearAccessRevoked(user) {
+		t.Errorf("clearAccessRevoked() = true for a user that was never revoked")
+	}
+	user.Annotations = map[string]string{accessRevokedAnnotation: "true", "other": "x"}
+	if !clearAccessRevoked(user) {
+		t.Errorf("clearAccessRevoked() = false for a revoked user")
+	}
+	if _, ok := user.Annotations[accessRevokedAnnotation]; ok || user.Annotations["other"] != "x" {
+		t.Errorf("clearAccessRevoked() left annotations %v", user.Annotations)
+	}
+}
+
+func TestUserReconcilerDisableAndReenable(t *testing.T) {
+	ctx := context.Background()
+	objs := append(userTestObjects("c-abc12", "c-def34"), &managementv3.ProjectRoleTemplateBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "platform-1a2b3c4d",
+			Namespace:   "p-xyz89",
+			Labels:      managedLabels("u-abc12", ""),
+			Annotations: map[string]string{projectAssignmentAnnotation: "platform"},
+		},
+		UserName:         "u-abc12",
+		ProjectName:      "c-abc12:p-xyz89",
+		RoleTemplateName: "project-member",
+	})
+	c := newFakeClient(objs...)
+	recorder := record.NewFakeRecorder(10)
+	r := &UserReconciler{Client: c, Recorder: recorder}
+	getUser := func() *managementv3.User {
+		t.Helper()
+		user := &managementv3.User{}
+		if err := c.Get(ctx, client.ObjectKey{Name: "u-abc12"}, user); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		return user
+	}
+	setEnabled := func(enabled bool) {
+		t.Helper()
+		user := getUser()
+		user.Enabled = &enabled
+		if err := c.Update(ctx, user); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+	bindings := func() (clusterBindings, projectBindings int) {
+		t.Helper()
+		var clusterBindingList managementv3.ClusterRoleTemplateBindingList
+		var projectBindingList managementv3.ProjectRoleTemplateBindingList
+		if err := c.List(ctx, &clusterBindingList, userBindings("u-abc12")); err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if err := c.List(ctx, &projectBindingList, userBindings("u-abc12")); err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		return len(clusterBindingList.Items), len(projectBindingList.Items)
+	}
+	reasons := func() string {
+		var reasons []string
+		for _, event := range drainEvents(recorder) {
+			reasons = append(reasons, strings.Fields(event)[1])
+		}
+		return strings.Join(reasons, ",")
+	}
+
+	if err := reconcileUser(t, r, "u-abc12"); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if clusterBindings, projectBindings := bindings(); clusterBindings != 2 || projectBindings != 1 {
+		t.Fatalf("user has %d cluster and %d project bindings, want 2 and 1", clusterBindings, projectBindings)
+	}
+	drainEvents(recorder)
+
+	t.Run("disabled", func(t *testing.T) {
+		setEnabled(false)
+		for i := 0; i < 2; i++ {
+			if err := reconcileUser(t, r, "u-abc12"); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+		}
+		if clusterBindings, projectBindings := bindings(); clusterBindings != 0 || projectBindings != 0 {
+			t.Errorf("user has %d cluster and %d project bindings, want none", clusterBindings, projectBindings)
+		}
+		user := getUser()
+		if controllerutil.ContainsFinalizer(user, userBindingsFinalizer) {
+			t.Errorf("finalizer kept on a user without bindings")
+		}
+		if user.Annotations[accessRevokedAnnotation] != "true" {
+			t.Errorf("annotations = %v, want %s", user.Annotations, accessRevokedAnnotation)
+		}
+		if got := reasons(); got != "AccessRevoked" {
+			t.Errorf("events = %s, want a single AccessRevoked", got)
+		}
+	})
+
+	t.Run("re-enabled", func(t *testing.T) {
+		setEnabled(true)
+		if err := reconcileUser(t, r, "u-abc12"); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		// Project bindings come back with their ProjectAssignment, which watches the user too.
+		if clusterBindings, _ := bindings(); clusterBindings != 2 {
+			t.Errorf("user has %d cluster bindings, want 2", clusterBindings)
+		}
+		user := getUser()
+		if _, ok := user.Annotations[accessRevokedAnnotation]; ok {
+			t.Errorf("annotations = %v, want %s removed", user.Annotations, accessRevokedAnnotation)
+		}
+		if !controllerutil.ContainsFinalizer(user, userBindingsFinalizer) {
+			t.Errorf("finalizer missing on a user with bindings")
+		}
+		if got := reasons(); got != "AccessRestored" {
+			t.Errorf("events = %s, want a single AccessRestored", got)
+		}
+	})
+}
@@ -1,10 +1,260 @@
 package controllers
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+const (
+	// roleTemplatesFileAPIVersion and roleTemplatesFileKind identify the supported version of the file format.
+	roleTemplatesFileAPIVersion = "permissions.xddevelopment.com/v1alpha1"
+	roleTemplatesFileKind       = "RoleMappingConfig"
+
+	// defaultRoleTemplatesFileResync is how often the role templates file is reloaded without a change.
+	defaultRoleTemplatesFileResync = 5 * time.Minute
+	// roleTemplatesFileRetryBase is the first delay before the file is reloaded after it or the role templates
+	// it refers to could not be read. Every further attempt doubles it, up to roleTemplatesFileRetryMax.
+	roleTemplatesFileRetryBase = time.Second
+	roleTemplatesFileRetryMax  = time.Minute
 )
 
+// RoleTemplatesFile loads role mapping rules from a file in the RoleMappingConfig format, written either
+// as YAML or as JSON:
+//
+//	apiVersion: permissions.xddevelopment.com/v1alpha1
+//	kind: RoleMappingConfig
+//	rules:
+//	- name: cluster-admin
+//	  substring: cluster-admin
+//	  roleTemplateName: cluster-admin
+//
+// The rules have the same fields as the rules of a RoleMapping. The file is loaded once and kept in memory.
+// When added to the manager, it watches the directory of the file, so that updates of a mounted ConfigMap
+// are picked up, and swaps in the new rules as a whole. A reload that fails to read the file or the role
+// templates keeps the rules loaded before and is retried shortly, only a file that fails validation replaces
+// them.
+type RoleTemplatesFile struct {
+	// Path of the file. A missing file is not an error.
+	Path string
+	// Reader is used to check that the referenced role templates exist. The check is skipped when nil.
+	Reader client.Reader
+	// ResyncPeriod is how often the file is reloaded even without a change, so that role templates created
+	// after it was loaded are found. Defaults to defaultRoleTemplatesFileResync.
+	ResyncPeriod time.Duration
+
+	mu          sync.Mutex
+	state       atomic.Pointer[roleTemplatesState]
+	changesOnce sync.Once
+	changes     chan event.GenericEvent
+}
+
+// roleTemplatesState is the outcome of loading the role templates file.
+type roleTemplatesState struct {
+	rules []mappingRule
+	found bool
+	err   error
+	// hash is the SHA-256 of the file content, empty while the file does not exist.
+	hash string
+	// revision counts the changes of the loaded content since start.
+	revision int64
+}
+
+// Rules returns the rules of the file as last loaded. found is false if the file does not exist.
+func (f *RoleTemplatesFile) Rules(ctx context.Context) (rules []mappingRule, found bool, err error) {
+	state := f.current(ctx)
+	return state.rules, state.found, state.err
+}
+
+// Check is a readiness check that fails while the file exists but cannot be loaded.
+func (f *RoleTemplatesFile) Check(req *http.Request) error {
+	return f.current(req.Context()).err
+}
+
+// Changes returns a channel that receives an event each time the loaded content of the file changes.
+func (f *RoleTemplatesFile) Changes() <-chan event.GenericEvent {
+	f.changesOnce.Do(func() {
+		f.changes = make(chan event.GenericEvent, 1)
+	})
+	return f.changes
+}
+
+// NeedLeaderElection makes every replica watch the file, the readiness check depends on it.
+func (f *RoleTemplatesFile) NeedLeaderElection() bool {
+	return false
+}
+
+// Start watches the file until ctx is done. It implements manager.Runnable.
+func (f *RoleTemplatesFile) Start(ctx context.Context) error {
+	resync := f.ResyncPeriod
+	if resync == 0 {
+		resync = defaultRoleTemplatesFileResync
+	}
+	ticker := time.NewTicker(resync)
+	defer ticker.Stop()
+
+	// Watch the directory rather than the file: a ConfigMap volume replaces the file by swapping a symlink.
+	var events <-chan fsnotify.Event
+	var watchErrors <-chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(f.Path)); err != nil {
+		globalLog.Error(err, "Failed to watch role templates file, relying on periodic reloads", "path", f.Path)
+	} else {
+		events, watchErrors = watcher.Events, watcher.Errors
+	}
+
+	// retry is set while a reload failed for a reason that says nothing about the file, see reload.
+	var retry <-chan time.Time
+	var retryDelay time.Duration
+	reload := func() {
+		if _, transient := f.reload(ctx); !transient {
+			retry, retryDelay = nil, 0
+			return
+		}
+		if retryDelay *= 2; retryDelay == 0 {
+			retryDelay = roleTemplatesFileRetryBase
+		}
+		if retryDelay > roleTemplatesFileRetryMax {
+			retryDelay = roleTemplatesFileRetryMax
+		}
+		retry = time.After(retryDelay)
+	}
+
+	reload()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-events:
+			reload()
+		case err := <-watchErrors:
+			globalLog.Error(err, "Error watching role templates file", "path", f.Path)
+		case <-ticker.C:
+			reload()
+		case <-retry:
+			reload()
+		}
+	}
+}
+
+// current returns the loaded state, loading the file first if that has not happened yet.
+func (f *RoleTemplatesFile) current(ctx context.Context) *roleTemplatesState {
+	if state := f.state.Load(); state != nil {
+		return state
+	}
+	state, _ := f.reload(ctx)
+	return state
+}
+
+// reload loads the file and swaps in the result if it differs from the current state. Every swap after the
+// first one is announced on the Changes channel. When the file or the role templates cannot be read, the
+// current state is kept, there is none only before the first load, and transient reports that the reload
+// should be retried.
+func (f *RoleTemplatesFile) reload(ctx context.Context) (state *roleTemplatesState, transient bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	next, transient := f.load(ctx)
+	previous := f.state.Load()
+	if transient && previous != nil {
+		globalLog.Error(next.err, "Failed to reload role templates file, keeping the rules loaded before", "path", f.Path, "revision", previous.revision)
+		return previous, true
+	}
+	if previous != nil && previous.hash == next.hash && errorString(previous.err) == errorString(next.err) {
+		return previous, transient
+	}
+	if previous != nil {
+		next.revision = previous.revision + 1
+	}
+	f.state.Store(next)
+
+	roleTemplatesFileRevision.Set(float64(next.revision))
+	roleTemplatesFileInfo.Reset()
+	roleTemplatesFileInfo.WithLabelValues(next.hash).Set(1)
+	if next.err != nil {
+		globalLog.Error(next.err, "Failed to load role templates file", "path", f.Path, "revision", next.revision)
+	} else {
+		globalLog.Info("Loaded role templates file", "path", f.Path, "found", next.found, "rules", len(next.rules), "revision", next.revision, "hash", next.hash)
+	}
+
+	if previous != nil {
+		f.Changes()
+		select {
+		case f.changes <- event.GenericEvent{Object: &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: filepath.Base(f.Path)}}}:
+		default:
+			// A resync is already pending.
+		}
+	}
+	return next, transient
+}
+
+// load reads, parses and validates the file. transient reports a failure to read the file or the role
+// templates, as opposed to a file that fails validation.
+func (f *RoleTemplatesFile) load(ctx context.Context) (state *roleTemplatesState, transient bool) {
+	data, err := readFileIfExists(f.Path)
+	if os.IsNotExist(err) {
+		return &roleTemplatesState{}, false
+	}
+	state = &roleTemplatesState{found: true}
+	if err != nil {
+		state.err = err
+		return state, true
+	}
+	sum := sha256.Sum256(data)
+	state.hash = hex.EncodeToString(sum[:])
+
+	parsed, err := parseRoleTemplatesFile(data)
+	if err != nil {
+		state.err = fmt.Errorf("%s: %w", f.Path, err)
+		return state, false
+	}
+	if f.Reader != nil {
+		if err := validateRoleTemplateReferences(ctx, f.Reader, parsed); err != nil {
+			state.err = fmt.Errorf("%s: %w", f.Path, err)
+			var problems fileErrors
+			return state, !errors.As(err, &problems)
+		}
+	}
+
+	state.rules = make([]mappingRule, 0, len(parsed))
+	for _, rule := range parsed {
+		compiled, _ := newMappingRule("", rule.RoleMappingRule)
+		state.rules = append(state.rules, compiled)
+	}
+	return state, false
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
 func readFileIfExists(filename string) ([]byte, error) {
 	if _, err := os.Stat(filename); os.IsNotExist(err) {
 		// file does not exist
@@ -13,18 +263,198 @@ func readFileIfExists(filename string) ([]byte, error) {
 	return os.ReadFile(filename)
 }
 
-func parseRoleTemplatesFile(data []byte) ([]struct {
-	substring    string
-	roleTemplate string
-}, error) {
-	var roleTemplates []struct {
-		substring    string
-		roleTemplate string
+// fileError is a problem found at a line of the role templates file.
+type fileError struct {
+	Line    int
+	Message string
+}
+
+// fileErrors collects every problem of the role templates file, so that they can be fixed in one go.
+type fileErrors []fileError
+
+func (e fileErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fe := range e {
+		messages = append(messages, fmt.Sprintf("line %d: %s", fe.Line, fe.Message))
 	}
+	return strings.Join(messages, "; ")
+}
 
-	err := json.Unmarshal(data, &roleTemplates)
-	if err != nil {
+// parsedRule is a rule of the role templates file along with the lines it was read from.
+type parsedRule struct {
+	permissionsv1alpha1.RoleMappingRule
+	Line             int
+	RoleTemplateLine int
+}
+
+// parseRoleTemplatesFile parses a RoleMappingConfig document and checks it against the schema: the apiVersion
+// and kind, the required keys, unknown keys, and rule names and substrings that are used more than once.
+func parseRoleTemplatesFile(data []byte) ([]parsedRule, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
 		return nil, err
 	}
-	return roleTemplates, nil
+	if len(doc.Content) == 0 {
+		return nil, fileErrors{{Line: 1, Message: "file is empty"}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fileErrors{{Line: root.Line, Message: "expected a mapping with apiVersion, kind and rules"}}
+	}
+
+	var errs fileErrors
+	fields := mappingFields(root, map[string]bool{"apiVersion": true, "kind": true, "rules": true}, "", &errs)
+	for _, header := range []struct{ key, expected string }{
+		{"apiVersion", roleTemplatesFileAPIVersion},
+		{"kind", roleTemplatesFileKind},
+	} {
+		key, expected := header.key, header.expected
+		value, ok := fields[key]
+		switch {
+		case !ok:
+			errs = append(errs, fileError{Line: root.Line, Message: fmt.Sprintf("missing key %q", key)})
+		case value.Value != expected:
+			errs = append(errs, fileError{Line: value.Line, Message: fmt.Sprintf("unsupported %s %q, expected %q", key, value.Value, expected)})
+		}
+	}
+
+	rulesNode, ok := fields["rules"]
+	if !ok {
+		errs = append(errs, fileError{Line: root.Line, Message: `missing key "rules"`})
+		return nil, errs
+	}
+	if rulesNode.Kind != yaml.SequenceNode {
+		errs = append(errs, fileError{Line: rulesNode.Line, Message: "rules must be a list"})
+		return nil, errs
+	}
+
+	ruleKeys := jsonFieldNames(reflect.TypeOf(permissionsv1alpha1.RoleMappingRule{}))
+	names := map[string]int{}
+	substrings := map[string]int{}
+	var rules []parsedRule
+	for i, item := range rulesNode.Content {
+		prefix := fmt.Sprintf("rules[%d]: ", i)
+		if item.Kind != yaml.MappingNode {
+			errs = append(errs, fileError{Line: item.Line, Message: prefix + "expected a mapping"})
+			continue
+		}
+		before := len(errs)
+		ruleFields := mappingFields(item, ruleKeys, prefix, &errs)
+		for _, key := range []string{"name", "roleTemplateName"} {
+			if _, ok := ruleFields[key]; !ok {
+				errs = append(errs, fileError{Line: item.Line, Message: prefix + fmt.Sprintf("missing key %q", key)})
+			}
+		}
+		if len(errs) > before {
+			continue
+		}
+
+		rule, err := decodeRule(item)
+		if err != nil {
+			errs = append(errs, fileError{Line: item.Line, Message: prefix + err.Error()})
+			continue
+		}
+		_, problems := newMappingRule("", rule)
+		if rule.BindTo == permissionsv1alpha1.GroupTarget {
+			problems = append(problems, "bindTo Group is only supported in RoleMappings")
+		}
+		for _, problem := range problems {
+			errs = append(errs, fileError{Line: item.Line, Message: prefix + problem})
+		}
+		if first, ok := names[rule.Name]; ok {
+			errs = append(errs, fileError{Line: ruleFields["name"].Line, Message: prefix + fmt.Sprintf("duplicate name %q, first used on line %d", rule.Name, first)})
+		} else {
+			names[rule.Name] = ruleFields["name"].Line
+		}
+		if substring, ok := ruleFields["substring"]; ok {
+			if first, ok := substrings[rule.Substring]; ok {
+				errs = append(errs, fileError{Line: substring.Line, Message: prefix + fmt.Sprintf("duplicate substring %q, first used on line %d", rule.Substring, first)})
+			} else {
+				substrings[rule.Substring] = substring.Line
+			}
+		}
+		rules = append(rules, parsedRule{RoleMappingRule: rule, Line: item.Line, RoleTemplateLine: ruleFields["roleTemplateName"].Line})
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return rules, nil
+}
+
+// validateRoleTemplateReferences reports the rules that refer to role templates that cluster bindings cannot
+// refer to, see roleTemplateProblem.
+func validateRoleTemplateReferences(ctx context.Context, reader client.Reader, rules []parsedRule) error {
+	var errs fileErrors
+	check := newRoleTemplateCheck(reader, clusterRoleContext)
+	for i, rule := range rules {
+		problem, err := check.problem(ctx, rule.RoleTemplateName)
+		if err != nil {
+			return err
+		}
+		if problem != "" {
+			errs = append(errs, fileError{Line: rule.RoleTemplateLine, Message: fmt.Sprintf("rules[%d]: %s", i, problem)})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// mappingFields returns the values of a YAML mapping by key, reporting unknown and repeated keys.
+func mappingFields(node *yaml.Node, known map[string]bool, prefix string, errs *fileErrors) map[string]*yaml.Node {
+	fields := map[string]*yaml.Node{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		switch {
+		case !known[key.Value]:
+			*errs = append(*errs, fileError{Line: key.Line, Message: prefix + fmt.Sprintf("unknown key %q", key.Value)})
+		case fields[key.Value] != nil:
+			*errs = append(*errs, fileError{Line: key.Line, Message: prefix + fmt.Sprintf("key %q is set more than once", key.Value)})
+		default:
+			fields[key.Value] = value
+		}
+	}
+	return fields
+}
+
+// decodeRule decodes a rule through JSON so that the json tags of the API type apply.
+func decodeRule(node *yaml.Node) (permissionsv1alpha1.RoleMappingRule, error) {
+	var rule permissionsv1alpha1.RoleMappingRule
+	var raw interface{}
+	if err := node.Decode(&raw); err != nil {
+		return rule, err
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return rule, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&rule)
+	return rule, err
+}
+
+// jsonFieldNames returns the JSON names of the fields of a struct type, including those of inlined structs.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" && field.Anonymous && strings.Contains(options, "inline") {
+			for inlined := range jsonFieldNames(field.Type) {
+				names[inlined] = true
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names[name] = true
+	}
+	return names
 }
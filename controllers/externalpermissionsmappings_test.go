@@ -0,0 +1,321 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestParseRoleTemplatesFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		wantRules int
+		wantErrs  []string
+	}{
+		{
+			name: "yaml",
+			data: `apiVersion: permissions.xddevelopment.com/v1alpha1
+kind: RoleMappingConfig
+rules:
+- name: cluster-admin
+  substring: cluster-admin
+  roleTemplateName: cluster-admin
+- name: developer
+  substring: developer
+  roleTemplateName: projects-create
+`,
+			wantRules: 2,
+		},
+		{
+			name: "json",
+			data: `{
+  "apiVersion": "permissions.xddevelopment.com/v1alpha1",
+  "kind": "RoleMappingConfig",
+  "rules": [
+    {"name": "cluster-auditor", "substring": "cluster-auditor", "roleTemplateName": "read-only"}
+  ]
+}`,
+			wantRules: 1,
+		},
+		{
+			name: "legacy format without header",
+			data: `[{"substring": "developer", "roleTemplate": "projects-create"}]`,
+			wantErrs: []string{
+				"line 1: expected a mapping with apiVersion, kind and rules",
+			},
+		},
+		{
+			name: "wrong version",
+			data: `apiVersion: permissions.xddevelopment.com/v2
+kind: RoleMappingConfig
+rules: []
+`,
+			wantErrs: []string{`line 1: unsupported apiVersion "permissions.xddevelopment.com/v2"`},
+		},
+		{
+			name: "missing and unknown keys",
+			data: `apiVersion: permissions.xddevelopment.com/v1alpha1
+kind: RoleMappingConfig
+rules:
+- name: developer
+  substring: developer
+  roleTemplate: projects-create
+`,
+			wantErrs: []string{
+				`line 6: rules[0]: unknown key "roleTemplate"`,
+				`line 4: rules[0]: missing key "roleTemplateName"`,
+			},
+		},
+		{
+			name: "duplicates",
+			data: `apiVersion: permissions.xddevelopment.com/v1alpha1
+kind: RoleMappingConfig
+rules:
+- name: developer
+  substring: developer
+  roleTemplateName: projects-create
+- name: developer
+  substring: developer
+  roleTemplateName: project-member
+`,
+			wantErrs: []string{
+				`line 7: rules[1]: duplicate name "developer", first used on line 4`,
+				`line 8: rules[1]: duplicate substring "developer", first used on line 5`,
+			},
+		},
+		{
+			name:     "empty",
+			data:     ``,
+			wantErrs: []string{"line 1: file is empty"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := parseRoleTemplatesFile([]byte(tt.data))
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(rules) != tt.wantRules {
+					t.Fatalf("got %d rules, want %d", len(rules), tt.wantRules)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected errors %q, got none", tt.wantErrs)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err.Error(), want)
+				}
+			}
+		})
+	}
+}
+
+// roleTemplatesFileContent returns a RoleMappingConfig with one rule binding roleTemplate.
+func roleTemplatesFileContent(roleTemplate string) string {
+	return `apiVersion: permissions.xddevelopment.com/v1alpha1
+kind: RoleMappingConfig
+rules:
+- name: admins
+  substring: admin
+  roleTemplateName: ` + roleTemplate + "\n"
+}
+
+// flakyReader fails every read while failing is set, as an unreachable API server would.
+type flakyReader struct {
+	client.Reader
+	failing atomic.Bool
+}
+
+func (r *flakyReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if r.failing.Load() {
+		return errors.New("connection refused")
+	}
+	return r.Reader.Get(ctx, key, obj, opts...)
+}
+
+// gaugeValues returns the values of the gauges a collector holds, by the value of their first label.
+func gaugeValues(t *testing.T, collector prometheus.Collector) map[string]float64 {
+	t.Helper()
+	metrics := make(chan prometheus.Metric, 10)
+	collector.Collect(metrics)
+	close(metrics)
+	values := map[string]float64{}
+	for metric := range metrics {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		var label string
+		if len(m.Label) > 0 {
+			label = m.Label[0].GetValue()
+		}
+		values[label] = m.Gauge.GetValue()
+	}
+	return values
+}
+
+func TestRoleTemplatesFileReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "role-templates.yaml")
+	write := func(roleTemplate string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(roleTemplatesFileContent(roleTemplate)), 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	reader := &flakyReader{Reader: roleTemplateReader{
+		"cluster-owner":  {Context: clusterRoleContext},
+		"cluster-member": {Context: clusterRoleContext},
+	}}
+	f := &RoleTemplatesFile{Path: path, Reader: reader}
+	roleTemplateOf := func() string {
+		t.Helper()
+		rules, found, err := f.Rules(ctx)
+		if err != nil || !found || len(rules) != 1 {
+			t.Fatalf("Rules() = %v, %v, %v, want one rule", rules, found, err)
+		}
+		return rules[0].RoleTemplateName
+	}
+	changed := func() bool {
+		select {
+		case <-f.Changes():
+			return true
+		default:
+			return false
+		}
+	}
+
+	write("cluster-owner")
+	if got := roleTemplateOf(); got != "cluster-owner" {
+		t.Fatalf("role template = %s, want cluster-owner", got)
+	}
+	first := f.state.Load()
+	if changed() {
+		t.Errorf("the first load was announced as a change")
+	}
+
+	t.Run("unchanged content", func(t *testing.T) {
+		if state, transient := f.reload(ctx); state != first || transient {
+			t.Errorf("reload() = %p, %v, want the loaded state %p", state, transient, first)
+		}
+		if changed() {
+			t.Errorf("an unchanged reload was announced as a change")
+		}
+	})
+
+	t.Run("transient error keeps the loaded rules", func(t *testing.T) {
+		write("cluster-member")
+		reader.failing.Store(true)
+		defer reader.failing.Store(false)
+		if _, transient := f.reload(ctx); !transient {
+			t.Errorf("reload() did not ask for a retry")
+		}
+		if got := roleTemplateOf(); got != "cluster-owner" {
+			t.Errorf("role template = %s, want the loaded cluster-owner", got)
+		}
+		if err := f.Check(httptest.NewRequest("GET", "/readyz", nil)); err != nil {
+			t.Errorf("Check() = %v, want the loaded state to stay ready", err)
+		}
+		if changed() {
+			t.Errorf("a failed reload was announced as a change")
+		}
+	})
+
+	t.Run("changed content", func(t *testing.T) {
+		if _, transient := f.reload(ctx); transient {
+			t.Errorf("reload() asked for a retry")
+		}
+		if got := roleTemplateOf(); got != "cluster-member" {
+			t.Errorf("role template = %s, want cluster-member", got)
+		}
+		if !changed() {
+			t.Errorf("the change was not announced")
+		}
+		state := f.state.Load()
+		if state.revision != first.revision+1 || state.hash == first.hash {
+			t.Errorf("revision %d, hash %s, want revision %d and a new hash", state.revision, state.hash, first.revision+1)
+		}
+		if got := gaugeValues(t, roleTemplatesFileRevision)[""]; got != float64(state.revision) {
+			t.Errorf("revision metric = %v, want %d", got, state.revision)
+		}
+		if got, want := gaugeValues(t, roleTemplatesFileInfo), map[string]float64{state.hash: 1}; len(got) != 1 || got[state.hash] != 1 {
+			t.Errorf("info metric = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("invalid content replaces the loaded rules", func(t *testing.T) {
+		write("cluster-admin")
+		if _, transient := f.reload(ctx); transient {
+			t.Errorf("reload() asked for a retry of a file that fails validation")
+		}
+		if _, _, err := f.Rules(ctx); err == nil || !strings.Contains(err.Error(), `role template "cluster-admin" not found`) {
+			t.Errorf("Rules() error = %v, want the missing role template", err)
+		}
+		if !changed() {
+			t.Errorf("the change was not announced")
+		}
+	})
+}
+
+func TestRoleTemplatesFileStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "role-templates.yaml")
+	if err := os.WriteFile(path, []byte(roleTemplatesFileContent("cluster-owner")), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	reader := &flakyReader{Reader: roleTemplateReader{
+		"cluster-owner":  {Context: clusterRoleContext},
+		"cluster-member": {Context: clusterRoleContext},
+	}}
+	reader.failing.Store(true)
+	f := &RoleTemplatesFile{Path: path, Reader: reader}
+	go func() {
+		if err := f.Start(ctx); err != nil {
+			t.Errorf("Start() error = %v", err)
+		}
+	}()
+	eventually := func(what string, condition func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); !condition(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+	loaded := func(roleTemplate string) func() bool {
+		return func() bool {
+			rules, _, err := f.Rules(ctx)
+			return err == nil && len(rules) == 1 && rules[0].RoleTemplateName == roleTemplate
+		}
+	}
+
+	// The first load fails while the role templates cannot be read, and is retried.
+	eventually("the first load", func() bool { return f.state.Load() != nil })
+	if _, _, err := f.Rules(ctx); err == nil {
+		t.Errorf("Rules() error = nil while the role templates cannot be read")
+	}
+	reader.failing.Store(false)
+	eventually("the retry", loaded("cluster-owner"))
+
+	// An update of the file is picked up without waiting for the resync.
+	if err := os.WriteFile(path, []byte(roleTemplatesFileContent("cluster-member")), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	eventually("the update", loaded("cluster-member"))
+}
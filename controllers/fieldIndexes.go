@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"context"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// bindingUserNameField, bindingGroupPrincipalNameField and bindingManagedByField index the role bindings by
+	// the user and the group principal they bind, and by the value of their managed-by label.
+	bindingUserNameField           = "userName"
+	bindingGroupPrincipalNameField = "groupPrincipalName"
+	bindingManagedByField          = "managedBy"
+
+	// anyGroupPrincipal is indexed under bindingGroupPrincipalNameField for every binding of a group, so that all
+	// group bindings can be listed at once. Principal IDs always carry a provider prefix, so it matches none.
+	anyGroupPrincipal = "*"
+)
+
+// IndexFields registers the field indexes the reconcilers look objects up by: ClusterRoleTemplateBindings,
+// ProjectRoleTemplateBindings and GlobalRoleBindings by user, group principal and managed-by label, Users by
+// Username and principal ID, and UserAttributes by login name. The cache then answers those lookups without going through every object. It must
+// be called once, before the reconcilers are started.
+func IndexFields(ctx context.Context, indexer client.FieldIndexer) error {
+	for _, obj := range []client.Object{
+		&managementv3.ClusterRoleTemplateBinding{},
+		&managementv3.ProjectRoleTemplateBinding{},
+		&managementv3.GlobalRoleBinding{},
+	} {
+		if err := indexer.IndexField(ctx, obj, bindingUserNameField, indexBindingUserName); err != nil {
+			return err
+		}
+		if err := indexer.IndexField(ctx, obj, bindingGroupPrincipalNameField, indexBindingGroupPrincipalName); err != nil {
+			return err
+		}
+		if err := indexer.IndexField(ctx, obj, bindingManagedByField, indexBindingManagedBy); err != nil {
+			return err
+		}
+	}
+	if err := indexer.IndexField(ctx, &managementv3.User{}, userUsernameField, indexUserUsername); err != nil {
+		return err
+	}
+	if err := indexer.IndexField(ctx, &managementv3.User{}, userPrincipalIDField, indexUserPrincipalIDs); err != nil {
+		return err
+	}
+	return indexer.IndexField(ctx, &managementv3.UserAttribute{}, userAttributeLoginNameField, indexUserAttributeLoginName)
+}
+
+func indexBindingUserName(obj client.Object) []string {
+	userName, _ := bindingSubjectNames(obj)
+	if userName == "" {
+		return nil
+	}
+	return []string{userName}
+}
+
+func indexBindingGroupPrincipalName(obj client.Object) []string {
+	_, groupPrincipalName := bindingSubjectNames(obj)
+	if groupPrincipalName == "" {
+		return nil
+	}
+	return []string{groupPrincipalName, anyGroupPrincipal}
+}
+
+func indexBindingManagedBy(obj client.Object) []string {
+	value := obj.GetLabels()[managedByLabel]
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// bindingSubjectNames returns the user and the group principal a role binding binds.
+func bindingSubjectNames(obj client.Object) (userName, groupPrincipalName string) {
+	switch binding := obj.(type) {
+	case *managementv3.ClusterRoleTemplateBinding:
+		return binding.UserName, binding.GroupPrincipalName
+	case *managementv3.ProjectRoleTemplateBinding:
+		return binding.UserName, binding.GroupPrincipalName
+	case *managementv3.GlobalRoleBinding:
+		return binding.UserName, binding.GroupPrincipalName
+	}
+	return "", ""
+}
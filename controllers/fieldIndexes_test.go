@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestBindingIndexes(t *testing.T) {
+	managed := metav1.ObjectMeta{Name: "b", Labels: managedLabels("u-abc12", "")}
+	tests := []struct {
+		name          string
+		binding       client.Object
+		wantUser      []string
+		wantGroup     []string
+		wantManagedBy []string
+	}{
+		{name: "cluster user binding", binding: &managementv3.ClusterRoleTemplateBinding{ObjectMeta: managed, UserName: "u-abc12"}, wantUser: []string{"u-abc12"}, wantManagedBy: []string{managedByLabelValue}},
+		{name: "project group binding", binding: &managementv3.ProjectRoleTemplateBinding{ObjectMeta: managed, GroupPrincipalName: "azuread_group://admins"}, wantGroup: []string{"azuread_group://admins", anyGroupPrincipal}, wantManagedBy: []string{managedByLabelValue}},
+		{name: "foreign global binding", binding: &managementv3.GlobalRoleBinding{UserName: "u-def34"}, wantUser: []string{"u-def34"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := indexBindingUserName(tt.binding); !reflect.DeepEqual(got, tt.wantUser) {
+				t.Errorf("indexBindingUserName() = %v, want %v", got, tt.wantUser)
+			}
+			if got := indexBindingGroupPrincipalName(tt.binding); !reflect.DeepEqual(got, tt.wantGroup) {
+				t.Errorf("indexBindingGroupPrincipalName() = %v, want %v", got, tt.wantGroup)
+			}
+			if got := indexBindingManagedBy(tt.binding); !reflect.DeepEqual(got, tt.wantManagedBy) {
+				t.Errorf("indexBindingManagedBy() = %v, want %v", got, tt.wantManagedBy)
+			}
+		})
+	}
+}
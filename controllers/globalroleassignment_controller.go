@@ -0,0 +1,284 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+const (
+	// globalRoleAssignmentAnnotation records the GlobalRoleAssignment a binding was created for.
+	globalRoleAssignmentAnnotation = "permissions.xddevelopment.com/globalroleassignment"
+	// globalRoleAssignmentFinalizer keeps a GlobalRoleAssignment around until its bindings are deleted.
+	globalRoleAssignmentFinalizer = "permissions.xddevelopment.com/globalroleassignment"
+)
+
+// GlobalRoleAssignmentReconciler reconciles a GlobalRoleAssignment object
+type GlobalRoleAssignmentReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Recorder records the replacement of bindings whose immutable fields changed.
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=permissions.xddevelopment.com,resources=globalroleassignments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=permissions.xddevelopment.com,resources=globalroleassignments/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=permissions.xddevelopment.com,resources=globalroleassignments/finalizers,verbs=update
+//+kubebuilder:rbac:groups=management.cattle.io,resources=globalrolebindings,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile turns a GlobalRoleAssignment into one GlobalRoleBinding per subject and matching user, and deletes
+// the bindings it created earlier for subjects that are gone or no longer match.
+func (r *GlobalRoleAssignmentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	assignment := &permissionsv1alpha1.GlobalRoleAssignment{}
+	if err := r.Get(ctx, req.NamespacedName, assignment); err != nil {
+		// The assignment has been deleted and its bindings cleaned up by the finalizer.
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if assignment.DeletionTimestamp != nil {
+		if err := r.pruneAssignmentBindings(ctx, assignment.Name, nil); err != nil {
+			return ctrl.Result{}, err
+		}
+		if controllerutil.RemoveFinalizer(assignment, globalRoleAssignmentFinalizer) {
+			if err := r.Update(ctx, assignment); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if controllerutil.AddFinalizer(assignment, globalRoleAssignmentFinalizer) {
+		if err := r.Update(ctx, assignment); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	status := permissionsv1alpha1.GlobalRoleAssignmentStatus{
+		ObservedGeneration: assignment.Generation,
+		Conditions:         append([]metav1.Condition(nil), assignment.Status.Conditions...),
+	}
+	var invalidSpec error
+	var matcher Matcher
+	if len(assignment.Spec.Subjects) == 0 && assignment.Spec.UserSelector == nil {
+		invalidSpec = fmt.Errorf("one of subjects or userSelector is required")
+	} else if assignment.Spec.UserSelector != nil {
+		var problems []string
+		if matcher, problems = compileUserSelector(*assignment.Spec.UserSelector); len(problems) > 0 {
+			invalidSpec = fmt.Errorf("userSelector: %s", strings.Join(problems, "; "))
+		}
+	}
+	if invalidSpec != nil {
+		globalLog.Info("GlobalRoleAssignment has an invalid spec", "globalRoleAssignment", assignment.Name, "error", invalidSpec.Error())
+		setStatusConditions(&status.Conditions, assignment.Generation, invalidSpec, nil)
+		return ctrl.Result{}, r.updateStatus(ctx, assignment, status)
+	}
+
+	subjects, failures, err := r.resolveGlobalSubjects(ctx, assignment, matcher)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	desired := map[client.ObjectKey]bool{}
+	for _, subject := range subjects {
+		binding := &managementv3.GlobalRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   assignmentBindingName(assignment.Name, "", subject, assignment.Spec.GlobalRoleName),
+				Labels: managedLabels("", ""),
+				Annotations: map[string]string{
+					globalRoleAssignmentAnnotation: assignment.Name,
+				},
+			},
+			GlobalRoleName: assignment.Spec.GlobalRoleName,
+		}
+		if subject.Kind == permissionsv1alpha1.GroupSubject {
+			binding.GroupPrincipalName = subject.Name
+		} else {
+			binding.UserName = subject.Name
+			binding.Labels[userLabel] = subject.Name
+		}
+
+		// Keep failed bindings out of pruning, an existing binding must survive a failed update.
+		desired[client.ObjectKeyFromObject(binding)] = true
+		if err := applyGlobalRoleBinding(ctx, r.Client, r.Recorder, binding); err != nil {
+			failures = append(failures, fmt.Sprintf("%s %s: %s", subject.Kind, subject.Name, err.Error()))
+			continue
+		}
+		// The binding may have been replaced under another name.
+		desired[client.ObjectKeyFromObject(binding)] = true
+		status.Bindings = append(status.Bindings, binding.Name)
+	}
+
+	if err := r.pruneAssignmentBindings(ctx, assignment.Name, desired); err != nil {
+		failures = append(failures, err.Error())
+	}
+
+	setStatusConditions(&status.Conditions, assignment.Generation, nil, failures)
+	if err := r.updateStatus(ctx, assignment, status); err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(failures) > 0 {
+		return ctrl.Result{}, fmt.Errorf("GlobalRoleAssignment %s is degraded: %d failure(s)", assignment.Name, len(failures))
+	}
+	return ctrl.Result{}, nil
+}
+
+// resolveGlobalSubjects returns the deduplicated subjects of the assignment as User subjects named by the user's
+// object name, or Group subjects named by the group principal ID. Users that are being deleted or are disabled are
+// left out.
+// matcher is the compiled UserSelector of the assignment, if any.
+func (r *GlobalRoleAssignmentReconciler) resolveGlobalSubjects(ctx context.Context, assignment *permissionsv1alpha1.GlobalRoleAssignment, matcher Matcher) ([]permissionsv1alpha1.Subject, []string, error) {
+	var unresolved []string
+	seen := map[permissionsv1alpha1.Subject]bool{}
+	for _, subject := range assignment.Spec.Subjects {
+		if subject.Kind == permissionsv1alpha1.GroupSubject {
+			seen[subject] = true
+			continue
+		}
+		user, err := findUser(ctx, r.Client, subject.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if user == nil {
+			globalLog.Info("Skipping subject that cannot be resolved", "kind", subject.Kind, "name", subject.Name)
+			unresolved = append(unresolved, fmt.Sprintf("%s %q not found", subject.Kind, subject.Name))
+			continue
+		}
+		if user.DeletionTimestamp == nil && !isDisabled(user) {
+			seen[permissionsv1alpha1.Subject{Kind: permissionsv1alpha1.UserSubject, Name: user.Name}] = true
+		}
+	}
+
+	if matcher != nil {
+		var userList managementv3.UserList
+		if err := r.List(ctx, &userList); err != nil {
+			return nil, nil, err
+		}
+		attributes, err := allUserAttributes(ctx, r.Client)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range userList.Items {
+			user := &userList.Items[i]
+			attribute := attributes[user.Name]
+			if user.DeletionTimestamp == nil && !isDisabled(user) && matcher.Match(userIdentity(user, attribute), groupPrincipals(attribute)) {
+				seen[permissionsv1alpha1.Subject{Kind: permissionsv1alpha1.UserSubject, Name: user.Name}] = true
+			}
+		}
+	}
+
+	subjects := make([]permissionsv1alpha1.Subject, 0, len(seen))
+	for subject := range seen {
+		subjects = append(subjects, subject)
+	}
+	sort.Slice(subjects, func(i, j int) bool {
+		if subjects[i].Kind != subjects[j].Kind {
+			return subjects[i].Kind < subjects[j].Kind
+		}
+		return subjects[i].Name < subjects[j].Name
+	})
+	return subjects, unresolved, nil
+}
+
+// updateStatus writes status to the assignment unless it is unchanged.
+func (r *GlobalRoleAssignmentReconciler) updateStatus(ctx context.Context, assignment *permissionsv1alpha1.GlobalRoleAssignment, status permissionsv1alpha1.GlobalRoleAssignmentStatus) error {
+	if equality.Semantic.DeepEqual(assignment.Status, status) {
+		return nil
+	}
+	assignment.Status = status
+	if err := r.Status().Update(ctx, assignment); err != nil {
+		globalLog.Error(err, "Failed to update GlobalRoleAssignment status", "globalRoleAssignment", assignment.Name)
+		return err
+	}
+	return nil
+}
+
+// pruneAssignmentBindings deletes the bindings created for the named assignment that are not in keep.
+func (r *GlobalRoleAssignmentReconciler) pruneAssignmentBindings(ctx context.Context, assignmentName string, keep map[client.ObjectKey]bool) error {
+	var bindingList managementv3.GlobalRoleBindingList
+	if err := r.List(ctx, &bindingList, managedBindings); err != nil {
+		return err
+	}
+
+	for i := range bindingList.Items {
+		binding := &bindingList.Items[i]
+		if binding.Annotations[globalRoleAssignmentAnnotation] != assignmentName || isUnmanaged(binding) || keep[client.ObjectKeyFromObject(binding)] {
+			continue
+		}
+		if err := r.Delete(ctx, binding); err != nil && !apierrors.IsNotFound(err) {
+			globalLog.Error(err, "Failed to delete GlobalRoleBinding", "name", binding.Name)
+			return err
+		}
+		globalLog.Info("Deleted GlobalRoleBinding no longer covered by GlobalRoleAssignment", "name", binding.Name, "globalRoleAssignment", assignmentName)
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *GlobalRoleAssignmentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&permissionsv1alpha1.GlobalRoleAssignment{}).
+		// Rancher and the UserReconciler write the status of users, which no assignment looks at.
+		Watches(&source.Kind{Type: &managementv3.User{}}, handler.EnqueueRequestsFromMapFunc(r.assignmentsForUser), builder.WithPredicates(userSelectionChanged)).
+		Watches(&source.Kind{Type: &managementv3.UserAttribute{}}, handler.EnqueueRequestsFromMapFunc(r.assignmentsForUser), builder.WithPredicates(identityRefreshed)).
+		Complete(r)
+}
+
+// assignmentsForUser requeues every GlobalRoleAssignment, since any of them may match a new, changed or deleted user.
+func (r *GlobalRoleAssignmentReconciler) assignmentsForUser(_ client.Object) []reconcile.Request {
+	var assignmentList permissionsv1alpha1.GlobalRoleAssignmentList
+	if err := r.List(context.Background(), &assignmentList); err != nil {
+		globalLog.Error(err, "Failed to list GlobalRoleAssignments")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(assignmentList.Items))
+	for _, assignment := range assignmentList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&assignment)})
+	}
+	return requests
+}
+
+// userSelectionChanged filters User events down to those that can change which users a GlobalRoleAssignment
+// binds: creation, deletion, and updates that disable or re-enable a user, start its deletion, or change what
+// subjects and a userSelector match, i.e. its Username, display name, principal IDs, labels or annotations.
+var userSelectionChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		if userEnabledChanged.Update(e) {
+			return true
+		}
+		oldUser, ok := e.ObjectOld.(*managementv3.User)
+		if !ok {
+			return false
+		}
+		newUser, ok := e.ObjectNew.(*managementv3.User)
+		if !ok {
+			return false
+		}
+		return (oldUser.DeletionTimestamp == nil) != (newUser.DeletionTimestamp == nil) ||
+			oldUser.Username != newUser.Username ||
+			oldUser.DisplayName != newUser.DisplayName ||
+			!equality.Semantic.DeepEqual(oldUser.PrincipalIDs, newUser.PrincipalIDs) ||
+			!equality.Semantic.DeepEqual(oldUser.Labels, newUser.Labels) ||
+			!equality.Semantic.DeepEqual(oldUser.Annotations, newUser.Annotations)
+	},
+	GenericFunc: func(event.GenericEvent) bool { return false },
+}
@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+func TestGlobalRoleAssignmentPruneAssignmentBindings(t *testing.T) {
+	ctx := context.Background()
+	binding := func(name, assignmentName string, unmanaged bool) *managementv3.GlobalRoleBinding {
+		annotations := map[string]string{globalRoleAssignmentAnnotation: assignmentName}
+		if unmanaged {
+			annotations[unmanagedAnnotation] = "true"
+		}
+		return &managementv3.GlobalRoleBinding{
+			ObjectMeta:     metav1.ObjectMeta{Name: name, Labels: managedLabels("u-abc12", ""), Annotations: annotations},
+			GlobalRoleName: "restricted-admin",
+			UserName:       "u-abc12",
+		}
+	}
+	pruned := map[string]bool{
+		"kept":      false,
+		"stale":     true,
+		"unmanaged": false,
+		"other":     false,
+	}
+	c := newFakeClient(
+		binding("kept", "admins", false),
+		binding("stale", "admins", false),
+		binding("unmanaged", "admins", true),
+		binding("other", "auditors", false),
+	)
+	r := &GlobalRoleAssignmentReconciler{Client: c}
+	if err := r.pruneAssignmentBindings(ctx, "admins", map[client.ObjectKey]bool{{Name: "kept"}: true}); err != nil {
+		t.Fatalf("pruneAssignmentBindings() error = %v", err)
+	}
+	for name, want := range pruned {
+		err := c.Get(ctx, client.ObjectKey{Name: name}, &managementv3.GlobalRoleBinding{})
+		if apierrors.IsNotFound(err) != want {
+			t.Errorf("binding %s pruned = %v, want %v", name, apierrors.IsNotFound(err), want)
+		}
+	}
+}
+
+func TestGlobalRoleAssignmentReconcile(t *testing.T) {
+	ctx := context.Background()
+	disabled := false
+	assignment := &permissionsv1alpha1.GlobalRoleAssignment{
+		ObjectMeta: metav1.ObjectMeta{Name: "admins"},
+		Spec: permissionsv1alpha1.GlobalRoleAssignmentSpec{
+			GlobalRoleName: "restricted-admin",
+			Subjects: []permissionsv1alpha1.Subject{
+				{Kind: permissionsv1alpha1.UserSubject, Name: "jdoe"},
+				{Kind: permissionsv1alpha1.GroupSubject, Name: "azuread_group://admins"},
+				{Kind: permissionsv1alpha1.UserSubject, Name: "nobody"},
+			},
+			UserSelector: &permissionsv1alpha1.UserSelector{Substring: "-admin"},
+		},
+	}
+	stale := &managementv3.GlobalRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "admins-stale",
+			Labels:      managedLabels("u-gone1", ""),
+			Annotations: map[string]string{globalRoleAssignmentAnnotation: "admins"},
+		},
+		GlobalRoleName: "restricted-admin",
+		UserName:       "u-gone1",
+	}
+	c := newFakeClient(
+		assignment,
+		stale,
+		&managementv3.User{ObjectMeta: metav1.ObjectMeta{Name: "u-abc12"}, Username: "jdoe"},
+		&managementv3.User{ObjectMeta: metav1.ObjectMeta{Name: "u-def34"}, Username: "asmith-admin"},
+		&managementv3.User{ObjectMeta: metav1.ObjectMeta{Name: "u-ghi56"}, Username: "left-admin", Enabled: &disabled},
+		&managementv3.User{ObjectMeta: metav1.ObjectMeta{Name: "u-jkl78"}, Username: "bwhite"},
+	)
+	r := &GlobalRoleAssignmentReconciler{Client: c}
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(assignment)}); err == nil {
+		t.Fatal("Reconcile() error = nil, want the unresolved subject reported")
+	}
+
+	var bindingList managementv3.GlobalRoleBindingList
+	if err := c.List(ctx, &bindingList); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	var got []string
+	for _, binding := range bindingList.Items {
+		if binding.GlobalRoleName != "restricted-admin" || binding.Annotations[globalRoleAssignmentAnnotation] != "admins" {
+			t.Errorf("binding %s = %+v, want restricted-admin of assignment admins", binding.Name, binding)
+		}
+		got = append(got, binding.UserName+binding.GroupPrincipalName)
+	}
+	sort.Strings(got)
+	if want := []string{"azuread_group://admins", "u-abc12", "u-def34"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bound subjects = %v, want %v", got, want)
+	}
+
+	if err := c.Get(ctx, client.ObjectKeyFromObject(assignment), assignment); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !meta.IsStatusConditionTrue(assignment.Status.Conditions, permissionsv1alpha1.ConditionDegraded) {
+		t.Errorf("conditions = %+v, want Degraded for the unresolved subject", assignment.Status.Conditions)
+	}
+	if len(assignment.Status.Bindings) != 3 {
+		t.Errorf("status.bindings = %v, want 3", assignment.Status.Bindings)
+	}
+}
+
+func TestUserSelectionChanged(t *testing.T) {
+	user := &managementv3.User{ObjectMeta: metav1.ObjectMeta{Name: "u-abc12", Labels: map[string]string{"team": "platform"}}, Username: "jdoe"}
+	tests := []struct {
+		name   string
+		update func(*managementv3.User)
+		want   bool
+	}{
+		{name: "status", update: func(u *managementv3.User) {
+			u.Status.Conditions = append(u.Status.Conditions, managementv3.UserCondition{Type: userBindingsCondition})
+		}},
+		{name: "label", update: func(u *managementv3.User) { u.Labels["team"] = "apps" }, want: true},
+		{name: "username", update: func(u *managementv3.User) { u.Username = "jsmith" }, want: true},
+		{name: "deletion", update: func(u *managementv3.User) { now := metav1.Now(); u.DeletionTimestamp = &now }, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := user.DeepCopy()
+			tt.update(updated)
+			if got := userSelectionChanged.Update(event.UpdateEvent{ObjectOld: user, ObjectNew: updated}); got != tt.want {
+				t.Errorf("Update() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
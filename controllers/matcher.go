@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+// Matcher decides whether a user is selected, given the group principals of the user.
+type Matcher interface {
+	Match(user *managementv3.User, groups []managementv3.Principal) bool
+}
+
+// userAttribute reads the values of one attribute of a user. Most attributes have a single value, principal IDs
+// and groups have several, and a missing label or annotation has none. For groups, key restricts the values to
+// the groups of one auth provider.
+type userAttribute struct {
+	name permissionsv1alpha1.MatchAttribute
+	key  string
+}
+
+func (a userAttribute) values(user *managementv3.User, groups []managementv3.Principal) []string {
+	switch a.name {
+	case permissionsv1alpha1.GroupPrincipalIDAttribute, permissionsv1alpha1.GroupNameAttribute:
+		var values []string
+		for _, group := range groups {
+			if a.key != "" && group.Provider != a.key {
+				continue
+			}
+			if a.name == permissionsv1alpha1.GroupPrincipalIDAttribute {
+				values = append(values, group.Name)
+			} else {
+				values = append(values, group.DisplayName)
+			}
+		}
+		return values
+	case permissionsv1alpha1.UsernameAttribute:
+		return []string{user.Username}
+	case permissionsv1alpha1.DisplayNameAttribute:
+		return []string{user.DisplayName}
+	case permissionsv1alpha1.PrincipalIDAttribute:
+		return user.PrincipalIDs
+	case permissionsv1alpha1.LabelAttribute:
+		if value, ok := user.Labels[a.key]; ok {
+			return []string{value}
+		}
+	case permissionsv1alpha1.AnnotationAttribute:
+		if value, ok := user.Annotations[a.key]; ok {
+			return []string{value}
+		}
+	}
+	return nil
+}
+
+// anyValue reports whether match holds for any value of the attribute.
+func (a userAttribute) anyValue(user *managementv3.User, groups []managementv3.Principal, match func(string) bool) bool {
+	for _, value := range a.values(user, groups) {
+		if match(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// exactMatcher selects users with an attribute equal to value.
+type exactMatcher struct {
+	attribute userAttribute
+	value     string
+}
+
+func (m exactMatcher) Match(user *managementv3.User, groups []managementv3.Principal) bool {
+	return m.attribute.anyValue(user, groups, func(v string) bool { return v == m.value })
+}
+
+// prefixMatcher selects users with an attribute that starts with prefix.
+type prefixMatcher struct {
+	attribute userAttribute
+	prefix    string
+}
+
+func (m prefixMatcher) Match(user *managementv3.User, groups []managementv3.Principal) bool {
+	return m.attribute.anyValue(user, groups, func(v string) bool { return strings.HasPrefix(v, m.prefix) })
+}
+
+// substringMatcher selects users with an attribute that contains substring.
+type substringMatcher struct {
+	attribute userAttribute
+	substring string
+}
+
+func (m substringMatcher) Match(user *managementv3.User, groups []managementv3.Principal) bool {
+	return m.attribute.anyValue(user, groups, func(v string) bool { return contains(v, m.substring) })
+}
+
+// regexMatcher selects users with an attribute matched as a whole by expression. Glob patterns are
+// translated to expressions as well.
+type regexMatcher struct {
+	attribute  userAttribute
+	expression *regexp.Regexp
+}
+
+func (m regexMatcher) Match(user *managementv3.User, groups []managementv3.Principal) bool {
+	return m.attribute.anyValue(user, groups, m.expression.MatchString)
+}
+
+// notMatcher selects the users its matcher does not select.
+type notMatcher struct {
+	Matcher
+}
+
+func (m notMatcher) Match(user *managementv3.User, groups []managementv3.Principal) bool {
+	return !m.Matcher.Match(user, groups)
+}
+
+// allMatcher selects the users every one of its matchers selects.
+type allMatcher []Matcher
+
+func (m allMatcher) Match(user *managementv3.User, groups []managementv3.Principal) bool {
+	for _, matcher := range m {
+		if !matcher.Match(user, groups) {
+			return false
+		}
+	}
+	return true
+}
+
+// compileUserSelector turns a selector into a Matcher, reporting every invalid entry.
+func compileUserSelector(selector permissionsv1alpha1.UserSelector) (Matcher, []string) {
+	var problems []string
+	if selector.Substring == "" && len(selector.Match) == 0 {
+		return nil, []string{"one of substring or match is required"}
+	}
+
+	var matchers allMatcher
+	if selector.Substring != "" {
+		matchers = append(matchers, substringMatcher{
+			attribute: userAttribute{name: permissionsv1alpha1.UsernameAttribute},
+			substring: selector.Substring,
+		})
+	}
+	for i, match := range selector.Match {
+		matcher, err := compileAttributeMatch(match)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("match[%d]: %s", i, err.Error()))
+			continue
+		}
+		matchers = append(matchers, matcher)
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return matchers, nil
+}
+
+// compileAttributeMatch turns a single AttributeMatch into a Matcher.
+func compileAttributeMatch(match permissionsv1alpha1.AttributeMatch) (Matcher, error) {
+	attribute := userAttribute{name: match.Attribute, key: match.Key}
+	switch match.Attribute {
+	case permissionsv1alpha1.UsernameAttribute, permissionsv1alpha1.DisplayNameAttribute, permissionsv1alpha1.PrincipalIDAttribute,
+		permissionsv1alpha1.GroupPrincipalIDAttribute, permissionsv1alpha1.GroupNameAttribute:
+	case permissionsv1alpha1.LabelAttribute, permissionsv1alpha1.AnnotationAttribute:
+		if match.Key == "" {
+			return nil, fmt.Errorf("key is required for attribute %s", match.Attribute)
+		}
+	default:
+		return nil, fmt.Errorf("unknown attribute %q", match.Attribute)
+	}
+
+	var matcher Matcher
+	switch match.Operator {
+	case permissionsv1alpha1.ExactOperator, "":
+		matcher = exactMatcher{attribute: attribute, value: match.Value}
+	case permissionsv1alpha1.PrefixOperator:
+		matcher = prefixMatcher{attribute: attribute, prefix: match.Value}
+	case permissionsv1alpha1.SubstringOperator:
+		matcher = substringMatcher{attribute: attribute, substring: match.Value}
+	case permissionsv1alpha1.GlobOperator:
+		matcher = regexMatcher{attribute: attribute, expression: regexp.MustCompile(globToRegex(match.Value))}
+	case permissionsv1alpha1.RegexOperator:
+		expression, err := regexp.Compile(`^(?:` + match.Value + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", match.Value, err)
+		}
+		matcher = regexMatcher{attribute: attribute, expression: expression}
+	default:
+		return nil, fmt.Errorf("unknown operator %q", match.Operator)
+	}
+
+	if match.Negate {
+		matcher = notMatcher{matcher}
+	}
+	return matcher, nil
+}
+
+// globToRegex translates a glob pattern to an anchored regular expression. Unlike path.Match, * also
+// matches slashes, which principal IDs such as azuread_user://... contain.
+func globToRegex(pattern string) string {
+	var expression strings.Builder
+	expression.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			expression.WriteString(".*")
+		case '?':
+			expression.WriteString(".")
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expression.WriteString("$")
+	return expression.String()
+}
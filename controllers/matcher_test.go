@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+func TestCompileUserSelector(t *testing.T) {
+	user := &managementv3.User{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "u-abc12",
+			Labels:      map[string]string{"team": "platform"},
+			Annotations: map[string]string{"example.com/role": "developer"},
+		},
+		Username:     "developer-lead",
+		DisplayName:  "Jane Doe",
+		PrincipalIDs: []string{"azuread_user://1234", "local://u-abc12"},
+	}
+	groups := []managementv3.Principal{
+		{ObjectMeta: metav1.ObjectMeta{Name: "azuread_group://5678"}, DisplayName: "platform-admins", Provider: "azuread"},
+		{ObjectMeta: metav1.ObjectMeta{Name: "openldap_group://cn=dev,dc=example"}, DisplayName: "dev", Provider: "openldap"},
+	}
+
+	tests := []struct {
+		name     string
+		selector permissionsv1alpha1.UserSelector
+		want     bool
+		wantErr  string
+	}{
+		{
+			name:     "substring",
+			selector: permissionsv1alpha1.UserSelector{Substring: "developer"},
+			want:     true,
+		},
+		{
+			name:     "exact username",
+			selector: selectorOf(permissionsv1alpha1.UsernameAttribute, "", permissionsv1alpha1.ExactOperator, "developer", false),
+			want:     false,
+		},
+		{
+			name:     "operator defaults to exact",
+			selector: selectorOf(permissionsv1alpha1.UsernameAttribute, "", "", "developer-lead", false),
+			want:     true,
+		},
+		{
+			name:     "prefix display name",
+			selector: selectorOf(permissionsv1alpha1.DisplayNameAttribute, "", permissionsv1alpha1.PrefixOperator, "Jane", false),
+			want:     true,
+		},
+		{
+			name:     "glob matches slashes in principal IDs",
+			selector: selectorOf(permissionsv1alpha1.PrincipalIDAttribute, "", permissionsv1alpha1.GlobOperator, "azuread_user://*", false),
+			want:     true,
+		},
+		{
+			name:     "glob is anchored",
+			selector: selectorOf(permissionsv1alpha1.PrincipalIDAttribute, "", permissionsv1alpha1.GlobOperator, "azuread_user", false),
+			want:     false,
+		},
+		{
+			name:     "regex must match the whole value",
+			selector: selectorOf(permissionsv1alpha1.UsernameAttribute, "", permissionsv1alpha1.RegexOperator, "developer", false),
+			want:     false,
+		},
+		{
+			name:     "regex",
+			selector: selectorOf(permissionsv1alpha1.UsernameAttribute, "", permissionsv1alpha1.RegexOperator, "developer(-lead)?", false),
+			want:     true,
+		},
+		{
+			name:     "label",
+			selector: selectorOf(permissionsv1alpha1.LabelAttribute, "team", permissionsv1alpha1.ExactOperator, "platform", false),
+			want:     true,
+		},
+		{
+			name:     "annotation",
+			selector: selectorOf(permissionsv1alpha1.AnnotationAttribute, "example.com/role", permissionsv1alpha1.SubstringOperator, "dev", false),
+			want:     true,
+		},
+		{
+			name:     "negated missing label",
+			selector: selectorOf(permissionsv1alpha1.LabelAttribute, "contractor", permissionsv1alpha1.ExactOperator, "true", true),
+			want:     true,
+		},
+		{
+			name:     "negation applies to all principal IDs",
+			selector: selectorOf(permissionsv1alpha1.PrincipalIDAttribute, "", permissionsv1alpha1.PrefixOperator, "local://", true),
+			want:     false,
+		},
+		{
+			name:     "group principal ID",
+			selector: selectorOf(permissionsv1alpha1.GroupPrincipalIDAttribute, "", permissionsv1alpha1.ExactOperator, "azuread_group://5678", false),
+			want:     true,
+		},
+		{
+			name:     "group name",
+			selector: selectorOf(permissionsv1alpha1.GroupNameAttribute, "", permissionsv1alpha1.GlobOperator, "platform-*", false),
+			want:     true,
+		},
+		{
+			name:     "group name restricted to a provider",
+			selector: selectorOf(permissionsv1alpha1.GroupNameAttribute, "openldap", permissionsv1alpha1.GlobOperator, "platform-*", false),
+			want:     false,
+		},
+		{
+			name: "all entries must match",
+			selector: permissionsv1alpha1.UserSelector{
+				Substring: "developer",
+				Match: []permissionsv1alpha1.AttributeMatch{
+					{Attribute: permissionsv1alpha1.LabelAttribute, Key: "team", Value: "platform"},
+					{Attribute: permissionsv1alpha1.DisplayNameAttribute, Value: "John Doe"},
+				},
+			},
+			want: false,
+		},
+		{
+			name:     "unknown operator",
+			selector: selectorOf(permissionsv1alpha1.UsernameAttribute, "", "Suffix", "lead", false),
+			wantErr:  `match[0]: unknown operator "Suffix"`,
+		},
+		{
+			name:     "empty selector",
+			selector: permissionsv1alpha1.UserSelector{},
+			wantErr:  "one of substring or match is required",
+		},
+		{
+			name:     "label without key",
+			selector: selectorOf(permissionsv1alpha1.LabelAttribute, "", permissionsv1alpha1.ExactOperator, "platform", false),
+			wantErr:  "match[0]: key is required for attribute Label",
+		},
+		{
+			name:     "invalid regex",
+			selector: selectorOf(permissionsv1alpha1.UsernameAttribute, "", permissionsv1alpha1.RegexOperator, "(", false),
+			wantErr:  `match[0]: invalid regex "("`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher, problems := compileUserSelector(tt.selector)
+			if tt.wantErr != "" {
+				if !strings.Contains(strings.Join(problems, "; "), tt.wantErr) {
+					t.Fatalf("problems %q do not contain %q", problems, tt.wantErr)
+				}
+				return
+			}
+			if len(problems) > 0 {
+				t.Fatalf("unexpected problems: %q", problems)
+			}
+			if got := matcher.Match(user, groups); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func selectorOf(attribute permissionsv1alpha1.MatchAttribute, key string, operator permissionsv1alpha1.MatchOperator, value string, negate bool) permissionsv1alpha1.UserSelector {
+	return permissionsv1alpha1.UserSelector{Match: []permissionsv1alpha1.AttributeMatch{
+		{Attribute: attribute, Key: key, Operator: operator, Value: value, Negate: negate},
+	}}
+}
@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	roleTemplatesFileRevision = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rancher_permissions_role_templates_file_revision",
+		Help: "Number of times the content of the role templates file changed since the operator started.",
+	})
+	roleTemplatesFileInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rancher_permissions_role_templates_file_info",
+		Help: "Always 1, labelled with the SHA-256 of the loaded role templates file. The hash is empty while the file does not exist.",
+	}, []string{"hash"})
+	bindingDriftTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "rancher_permissions_binding_drift_total",
+		Help: "Number of managed ClusterRoleTemplateBindings deleted or modified outside of the operator, by change.",
+	}, []string{"change"})
+	usersPendingPrincipal = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "rancher_permissions_users_pending_principal",
+		Help: "Number of users the rules are waiting to bind until Rancher attaches a principal ID to them.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(roleTemplatesFileRevision, roleTemplatesFileInfo, bindingDriftTotal, usersPendingPrincipal)
+}
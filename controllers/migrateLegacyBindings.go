@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// migrateLegacyBindings labels the bindings created under the created-by-pod annotation the way bindings are
+// labelled now, and removes the annotation, so that label selectors find them.
+func migrateLegacyBindings(ctx context.Context, c client.Client, reader client.Reader) error {
+	var clusterBindingList managementv3.ClusterRoleTemplateBindingList
+	if err := reader.List(ctx, &clusterBindingList); err != nil {
+		return err
+	}
+	for i := range clusterBindingList.Items {
+		binding := &clusterBindingList.Items[i]
+		if err := relabelLegacyBinding(ctx, c, "ClusterRoleTemplateBinding", binding, binding.UserName, legacyRuleName(binding)); err != nil {
+			return err
+		}
+	}
+
+	var projectBindingList managementv3.ProjectRoleTemplateBindingList
+	if err := reader.List(ctx, &projectBindingList); err != nil {
+		return err
+	}
+	for i := range projectBindingList.Items {
+		binding := &projectBindingList.Items[i]
+		if err := relabelLegacyBinding(ctx, c, "ProjectRoleTemplateBinding", binding, binding.UserName, ""); err != nil {
+			return err
+		}
+	}
+
+	var globalBindingList managementv3.GlobalRoleBindingList
+	if err := reader.List(ctx, &globalBindingList); err != nil {
+		return err
+	}
+	for i := range globalBindingList.Items {
+		binding := &globalBindingList.Items[i]
+		if err := relabelLegacyBinding(ctx, c, "GlobalRoleBinding", binding, binding.UserName, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relabelLegacyBinding moves a binding carrying the legacy annotation over to the labels. Other bindings, and
+// bindings a human took over, are left alone.
+func relabelLegacyBinding(ctx context.Context, c client.Client, kind string, binding client.Object, userName, ruleName string) error {
+	annotations := binding.GetAnnotations()
+	if annotations[legacyCreatedByAnnotation] != legacyCreatedByAnnotationValue || isUnmanaged(binding) {
+		return nil
+	}
+	labels := binding.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for key, value := range managedLabels(userName, ruleName) {
+		labels[key] = value
+	}
+	binding.SetLabels(labels)
+	delete(annotations, legacyCreatedByAnnotation)
+	binding.SetAnnotations(annotations)
+
+	if err := c.Update(ctx, binding); err != nil {
+		// A binding deleted or updated meanwhile, e.g. relabelled by its reconciler, is no longer a concern.
+		if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+			return nil
+		}
+		return err
+	}
+	globalLog.Info("Relabelled binding created under the created-by-pod annotation", "kind", kind,
+		"Name", binding.GetName(), "Namespace", binding.GetNamespace())
+	return nil
+}
+
+// legacyRuleName recovers the rule a binding of the UserReconciler was created for from the binding name, which
+// is the user name, the cluster name and the rule name joined by dashes.
+func legacyRuleName(binding *managementv3.ClusterRoleTemplateBinding) string {
+	if binding.UserName == "" || binding.Annotations[clusterAssignmentAnnotation] != "" {
+		return ""
+	}
+	prefix := binding.UserName + "-" + binding.ClusterName + "-"
+	if !strings.HasPrefix(binding.Name, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(binding.Name, prefix)
+}
@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestLegacyRuleName(t *testing.T) {
+	tests := []struct {
+		name    string
+		binding *managementv3.ClusterRoleTemplateBinding
+		want    string
+	}{
+		{
+			name:    "user rule binding",
+			binding: &managementv3.ClusterRoleTemplateBinding{ObjectMeta: metav1.ObjectMeta{Name: "u-abc12-c-abc12-cluster-owners"}, UserName: "u-abc12", ClusterName: "c-abc12"},
+			want:    "cluster-owners",
+		},
+		{
+			name:    "name of another cluster",
+			binding: &managementv3.ClusterRoleTemplateBinding{ObjectMeta: metav1.ObjectMeta{Name: "u-abc12-c-def34-owners"}, UserName: "u-abc12", ClusterName: "c-abc12"},
+		},
+		{
+			name:    "group binding",
+			binding: &managementv3.ClusterRoleTemplateBinding{ObjectMeta: metav1.ObjectMeta{Name: "-c-abc12-owners"}, GroupPrincipalName: "azuread_group://admins", ClusterName: "c-abc12"},
+		},
+		{
+			name: "cluster assignment binding",
+			binding: &managementv3.ClusterRoleTemplateBinding{
+				ObjectMeta:  metav1.ObjectMeta{Name: "u-abc12-c-abc12-platform", Annotations: map[string]string{clusterAssignmentAnnotation: "platform"}},
+				UserName:    "u-abc12",
+				ClusterName: "c-abc12",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := legacyRuleName(tt.binding); got != tt.want {
+				t.Errorf("legacyRuleName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelabelLegacyBinding(t *testing.T) {
+	legacy := map[string]string{legacyCreatedByAnnotation: legacyCreatedByAnnotationValue}
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		userName        string
+		ruleName        string
+		wantLabels      map[string]string
+		wantAnnotations map[string]string
+	}{
+		{
+			name:        "user rule binding",
+			annotations: legacy,
+			userName:    "u-abc12",
+			ruleName:    "owners",
+			wantLabels:  managedLabels("u-abc12", "owners"),
+		},
+		{
+			name:        "group binding",
+			annotations: legacy,
+			wantLabels:  managedLabels("", ""),
+		},
+		{
+			name:            "binding of another pod",
+			annotations:     map[string]string{legacyCreatedByAnnotation: "other-controller"},
+			userName:        "u-abc12",
+			wantAnnotations: map[string]string{legacyCreatedByAnnotation: "other-controller"},
+		},
+		{
+			name:            "unmanaged binding",
+			annotations:     map[string]string{legacyCreatedByAnnotation: legacyCreatedByAnnotationValue, unmanagedAnnotation: "true"},
+			userName:        "u-abc12",
+			wantAnnotations: map[string]string{legacyCreatedByAnnotation: legacyCreatedByAnnotationValue, unmanagedAnnotation: "true"},
+		},
+		{
+			name:     "foreign binding",
+			userName: "u-abc12",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{}
+			for key, value := range tt.annotations {
+				annotations[key] = value
+			}
+			binding := &managementv3.GlobalRoleBinding{
+				ObjectMeta:     metav1.ObjectMeta{Name: "b", Annotations: annotations},
+				GlobalRoleName: "user-base",
+				UserName:       tt.userName,
+			}
+			c := newFakeClient(binding)
+			if err := relabelLegacyBinding(context.Background(), c, "GlobalRoleBinding", binding, tt.userName, tt.ruleName); err != nil {
+				t.Fatalf("relabelLegacyBinding() error = %v", err)
+			}
+
+			got := &managementv3.GlobalRoleBinding{}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(binding), got); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if len(got.Labels) > 0 || len(tt.wantLabels) > 0 {
+				if !reflect.DeepEqual(got.Labels, tt.wantLabels) {
+					t.Errorf("labels = %v, want %v", got.Labels, tt.wantLabels)
+				}
+			}
+			if len(got.Annotations) > 0 || len(tt.wantAnnotations) > 0 {
+				if !reflect.DeepEqual(got.Annotations, tt.wantAnnotations) {
+					t.Errorf("annotations = %v, want %v", got.Annotations, tt.wantAnnotations)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// pendingPrincipalRequeueBase is the first delay before a user without principal IDs is reconciled again.
+	// Every further attempt doubles it, up to pendingPrincipalRequeueMax.
+	pendingPrincipalRequeueBase = 10 * time.Second
+	pendingPrincipalRequeueMax  = 10 * time.Minute
+)
+
+// pendingPrincipals counts the reconciles of the users still waiting for Rancher to attach a principal ID, so
+// that their requeues back off. The zero value is ready to use.
+type pendingPrincipals struct {
+	mu       sync.Mutex
+	attempts map[string]int
+}
+
+// wait records another reconcile of the named user without principal IDs. It returns the delay before the
+// next one, and whether the user was not pending before.
+func (p *pendingPrincipals) wait(userName string) (time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.attempts == nil {
+		p.attempts = map[string]int{}
+	}
+	attempts, pending := p.attempts[userName]
+	p.attempts[userName] = attempts + 1
+	usersPendingPrincipal.Set(float64(len(p.attempts)))
+
+	delay := pendingPrincipalRequeueBase
+	for i := 0; i < attempts && delay < pendingPrincipalRequeueMax; i++ {
+		delay *= 2
+	}
+	if delay > pendingPrincipalRequeueMax {
+		delay = pendingPrincipalRequeueMax
+	}
+	return delay, !pending
+}
+
+// done forgets the named user, once it has a principal ID or is gone.
+func (p *pendingPrincipals) done(userName string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.attempts, userName)
+	usersPendingPrincipal.Set(float64(len(p.attempts)))
+}
@@ -0,0 +1,29 @@
+package controllers
+
+import "strings"
+
+// preferredPrincipalID returns the principal ID of the first of providers the user has a principal of, or the
+// first principal ID when it has none of them. It is empty for users without principal IDs.
+func preferredPrincipalID(principalIDs []string, providers []string) string {
+	for _, provider := range providers {
+		for _, principalID := range principalIDs {
+			if principalProvider(principalID) == provider {
+				return principalID
+			}
+		}
+	}
+	if len(principalIDs) > 0 {
+		return principalIDs[0]
+	}
+	return ""
+}
+
+// principalProvider returns the auth provider of a principal ID, e.g. azuread for azuread_user://1234 and local
+// for local://u-abc12.
+func principalProvider(principalID string) string {
+	scheme, _, found := strings.Cut(principalID, "://")
+	if !found {
+		return ""
+	}
+	return strings.TrimSuffix(scheme, "_user")
+}
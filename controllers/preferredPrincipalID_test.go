@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPreferredPrincipalID(t *testing.T) {
+	principalIDs := []string{"local://u-abc12", "github_user://1234", "azuread_user://5678"}
+	tests := []struct {
+		name         string
+		principalIDs []string
+		providers    []string
+		want         string
+	}{
+		{name: "no preference", principalIDs: principalIDs, want: "local://u-abc12"},
+		{name: "preferred provider", principalIDs: principalIDs, providers: []string{"azuread"}, want: "azuread_user://5678"},
+		{name: "first available provider", principalIDs: principalIDs, providers: []string{"okta", "github", "azuread"}, want: "github_user://1234"},
+		{name: "no preferred provider", principalIDs: principalIDs, providers: []string{"okta"}, want: "local://u-abc12"},
+		{name: "no principal", providers: []string{"azuread"}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := preferredPrincipalID(tt.principalIDs, tt.providers); got != tt.want {
+				t.Errorf("preferredPrincipalID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPendingPrincipalsBackOff(t *testing.T) {
+	var pending pendingPrincipals
+	want := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second, 160 * time.Second, 320 * time.Second, 10 * time.Minute, 10 * time.Minute}
+	for i, wantDelay := range want {
+		delay, first := pending.wait("u-abc12")
+		if delay != wantDelay || first != (i == 0) {
+			t.Errorf("attempt %d: wait() = %v, %v, want %v, %v", i, delay, first, wantDelay, i == 0)
+		}
+	}
+
+	pending.done("u-abc12")
+	if delay, first := pending.wait("u-abc12"); delay != pendingPrincipalRequeueBase || !first {
+		t.Errorf("after done: wait() = %v, %v, want %v, true", delay, first, pendingPrincipalRequeueBase)
+	}
+}
@@ -0,0 +1,272 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+const (
+	// projectAssignmentAnnotation records the ProjectAssignment a binding was created for.
+	projectAssignmentAnnotation = "permissions.xddevelopment.com/projectassignment"
+	// projectAssignmentFinalizer keeps a ProjectAssignment around until its bindings are deleted.
+	projectAssignmentFinalizer = "permissions.xddevelopment.com/projectassignment"
+)
+
+// ProjectAssignmentReconciler reconciles a ProjectAssignment object
+type ProjectAssignmentReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Recorder records the replacement of bindings whose immutable fields changed.
+	Recorder record.EventRecorder
+	// PrincipalProviders lists the auth providers, most preferred first, whose principal ID goes into the
+	// bindings of users with several.
+	PrincipalProviders []string
+}
+
+//+kubebuilder:rbac:groups=permissions.xddevelopment.com,resources=projectassignments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=permissions.xddevelopment.com,resources=projectassignments/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=permissions.xddevelopment.com,resources=projectassignments/finalizers,verbs=update
+//+kubebuilder:rbac:groups=management.cattle.io,resources=projects,verbs=get;list;watch
+//+kubebuilder:rbac:groups=management.cattle.io,resources=projectroletemplatebindings,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile turns a ProjectAssignment into one ProjectRoleTemplateBinding per selected project, subject and
+// role template, and deletes the bindings it created earlier that are no longer part of that set.
+func (r *ProjectAssignmentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	assignment := &permissionsv1alpha1.ProjectAssignment{}
+	if err := r.Get(ctx, req.NamespacedName, assignment); err != nil {
+		// The assignment has been deleted and its bindings cleaned up by the finalizer.
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if assignment.DeletionTimestamp != nil {
+		if err := r.pruneAssignmentBindings(ctx, assignment.Name, nil); err != nil {
+			return ctrl.Result{}, err
+		}
+		if controllerutil.RemoveFinalizer(assignment, projectAssignmentFinalizer) {
+			if err := r.Update(ctx, assignment); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if controllerutil.AddFinalizer(assignment, projectAssignmentFinalizer) {
+		if err := r.Update(ctx, assignment); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	status := permissionsv1alpha1.ProjectAssignmentStatus{
+		ObservedGeneration: assignment.Generation,
+		Conditions:         append([]metav1.Condition(nil), assignment.Status.Conditions...),
+	}
+	if err := validateProjectAssignment(assignment); err != nil {
+		globalLog.Info("ProjectAssignment has an invalid spec", "projectAssignment", assignment.Name, "error", err.Error())
+		setStatusConditions(&status.Conditions, assignment.Generation, err, nil)
+		return ctrl.Result{}, r.updateStatus(ctx, assignment, status)
+	}
+
+	projects, err := selectProjects(ctx, r.Client, &assignment.Spec)
+	if err != nil {
+		globalLog.Error(err, "Failed to select projects for ProjectAssignment", "projectAssignment", assignment.Name)
+		return ctrl.Result{}, err
+	}
+
+	subjects, failures, err := resolveSubjects(ctx, r.Client, assignment.Spec.Subjects, r.PrincipalProviders)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// Role templates Rancher would not bind get no new bindings, but keep those created before.
+	roleTemplateProblems, roleTemplateFailures, err := checkAssignmentRoleTemplates(ctx, r.Client, assignment.Spec.RoleTemplateNames, projectRoleContext)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	failures = append(failures, roleTemplateFailures...)
+
+	// Each project is applied on its own so that a failing project namespace does not block the others.
+	desired := map[client.ObjectKey]bool{}
+	for _, project := range projects {
+		projectID := project.Namespace + ":" + project.Name
+		status.MatchedProjects = append(status.MatchedProjects, projectID)
+		projectStatus := permissionsv1alpha1.ProjectBindingStatus{ProjectName: projectID}
+		for i, subject := range assignment.Spec.Subjects {
+			if subjects[i] == nil {
+				continue
+			}
+			for j, roleTemplateName := range assignment.Spec.RoleTemplateNames {
+				binding := &managementv3.ProjectRoleTemplateBinding{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      assignmentBindingName(assignment.Name, projectID, subject, roleTemplateName),
+						Namespace: project.Name,
+						Labels:    managedLabels(subjects[i].UserName, ""),
+						Annotations: map[string]string{
+							projectAssignmentAnnotation: assignment.Name,
+						},
+					},
+					UserName:           subjects[i].UserName,
+					UserPrincipalName:  subjects[i].UserPrincipalName,
+					GroupPrincipalName: subjects[i].GroupPrincipalName,
+					ProjectName:        projectID,
+					RoleTemplateName:   roleTemplateName,
+				}
+
+				// Keep failed bindings out of pruning, an existing binding must survive a failed update.
+				desired[client.ObjectKeyFromObject(binding)] = true
+				if roleTemplateProblems[j] != "" {
+					continue
+				}
+				if err := applyProjectRoleTemplateBinding(ctx, r.Client, r.Recorder, binding); err != nil {
+					projectStatus.Error = err.Error()
+					continue
+				}
+				// The binding may have been replaced under another name.
+				desired[client.ObjectKeyFromObject(binding)] = true
+				projectStatus.Bindings = append(projectStatus.Bindings, binding.Name)
+			}
+		}
+		if projectStatus.Error != "" {
+			failures = append(failures, fmt.Sprintf("project %s: %s", projectID, projectStatus.Error))
+		}
+		status.Projects = append(status.Projects, projectStatus)
+	}
+
+	if err := r.pruneAssignmentBindings(ctx, assignment.Name, desired); err != nil {
+		failures = append(failures, err.Error())
+	}
+
+	setStatusConditions(&status.Conditions, assignment.Generation, nil, failures)
+	if err := r.updateStatus(ctx, assignment, status); err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(failures) > 0 {
+		return ctrl.Result{}, fmt.Errorf("ProjectAssignment %s is degraded: %d failure(s)", assignment.Name, len(failures))
+	}
+	return ctrl.Result{}, nil
+}
+
+// updateStatus writes status to the assignment unless it is unchanged.
+func (r *ProjectAssignmentReconciler) updateStatus(ctx context.Context, assignment *permissionsv1alpha1.ProjectAssignment, status permissionsv1alpha1.ProjectAssignmentStatus) error {
+	if equality.Semantic.DeepEqual(assignment.Status, status) {
+		return nil
+	}
+	assignment.Status = status
+	if err := r.Status().Update(ctx, assignment); err != nil {
+		globalLog.Error(err, "Failed to update ProjectAssignment status", "projectAssignment", assignment.Name)
+		return err
+	}
+	return nil
+}
+
+// pruneAssignmentBindings deletes the bindings created for the named assignment that are not in keep.
+func (r *ProjectAssignmentReconciler) pruneAssignmentBindings(ctx context.Context, assignmentName string, keep map[client.ObjectKey]bool) error {
+	var bindingList managementv3.ProjectRoleTemplateBindingList
+	if err := r.List(ctx, &bindingList, managedBindings); err != nil {
+		return err
+	}
+
+	for i := range bindingList.Items {
+		binding := &bindingList.Items[i]
+		if binding.Annotations[projectAssignmentAnnotation] != assignmentName || isUnmanaged(binding) || keep[client.ObjectKeyFromObject(binding)] {
+			continue
+		}
+		if err := r.Delete(ctx, binding); err != nil && !apierrors.IsNotFound(err) {
+			globalLog.Error(err, "Failed to delete ProjectRoleTemplateBinding", "name", binding.Name, "namespace", binding.Namespace)
+			return err
+		}
+		globalLog.Info("Deleted ProjectRoleTemplateBinding no longer covered by ProjectAssignment", "name", binding.Name, "namespace", binding.Namespace, "projectAssignment", assignmentName)
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ProjectAssignmentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&permissionsv1alpha1.ProjectAssignment{}).
+		Watches(&source.Kind{Type: &managementv3.Project{}}, handler.EnqueueRequestsFromMapFunc(r.assignmentsForProject)).
+		// Disabled users lose their bindings, re-enabled ones get them back.
+		Watches(&source.Kind{Type: &managementv3.User{}}, handler.EnqueueRequestsFromMapFunc(r.assignmentsForProject), builder.WithPredicates(userEnabledChanged)).
+		Watches(&source.Kind{Type: &managementv3.RoleTemplate{}}, handler.EnqueueRequestsFromMapFunc(r.assignmentsForProject), builder.WithPredicates(roleTemplateValidityChanged)).
+		Complete(r)
+}
+
+// assignmentsForProject requeues every ProjectAssignment, since any of them may select a new or changed project,
+// name a user that was disabled or re-enabled, or a role template that changed.
+func (r *ProjectAssignmentReconciler) assignmentsForProject(_ client.Object) []reconcile.Request {
+	var assignmentList permissionsv1alpha1.ProjectAssignmentList
+	if err := r.List(context.Background(), &assignmentList); err != nil {
+		globalLog.Error(err, "Failed to list ProjectAssignments")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(assignmentList.Items))
+	for _, assignment := range assignmentList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&assignment)})
+	}
+	return requests
+}
+
+// validateProjectAssignment reports the spec errors that the CRD schema cannot catch.
+func validateProjectAssignment(assignment *permissionsv1alpha1.ProjectAssignment) error {
+	spec := assignment.Spec
+	if len(spec.ProjectNames) == 0 && spec.ProjectSelector == nil && len(spec.Projects) == 0 {
+		return fmt.Errorf("one of projectNames, projectSelector or projects is required")
+	}
+	if spec.ProjectSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(spec.ProjectSelector); err != nil {
+			return fmt.Errorf("invalid projectSelector: %w", err)
+		}
+	}
+	return nil
+}
+
+// selectProjects returns the projects matched by any of the name, label or cluster and display name selectors.
+func selectProjects(ctx context.Context, c client.Client, spec *permissionsv1alpha1.ProjectAssignmentSpec) ([]managementv3.Project, error) {
+	var projectList managementv3.ProjectList
+	if err := c.List(ctx, &projectList); err != nil {
+		return nil, err
+	}
+
+	labelSelector := labels.Nothing()
+	if spec.ProjectSelector != nil {
+		var err error
+		if labelSelector, err = metav1.LabelSelectorAsSelector(spec.ProjectSelector); err != nil {
+			return nil, err
+		}
+	}
+
+	listed := map[string]bool{}
+	for _, name := range spec.ProjectNames {
+		listed[name] = true
+	}
+	referenced := map[permissionsv1alpha1.ProjectReference]bool{}
+	for _, reference := range spec.Projects {
+		referenced[reference] = true
+	}
+
+	var projects []managementv3.Project
+	for _, project := range projectList.Items {
+		reference := permissionsv1alpha1.ProjectReference{ClusterName: project.Namespace, DisplayName: project.Spec.DisplayName}
+		if listed[project.Name] || listed[project.Namespace+":"+project.Name] || referenced[reference] ||
+			labelSelector.Matches(labels.Set(project.Labels)) {
+			projects = append(projects, project)
+		}
+	}
+	return projects, nil
+}
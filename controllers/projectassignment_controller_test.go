@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestProjectAssignmentPruneAssignmentBindings(t *testing.T) {
+	ctx := context.Background()
+	binding := func(name, assignmentName string, unmanaged bool) *managementv3.ProjectRoleTemplateBinding {
+		annotations := map[string]string{projectAssignmentAnnotation: assignmentName}
+		if unmanaged {
+			annotations[unmanagedAnnotation] = "true"
+		}
+		return &managementv3.ProjectRoleTemplateBinding{
+			ObjectMeta:       metav1.ObjectMeta{Name: name, Namespace: "p-abc12", Labels: managedLabels("u-abc12", ""), Annotations: annotations},
+			RoleTemplateName: "project-member",
+			UserName:         "u-abc12",
+			ProjectName:      "c-abc12:p-abc12",
+		}
+	}
+	bindings := map[string]bool{
+		"kept":      false,
+		"stale":     true,
+		"unmanaged": false,
+		"other":     false,
+	}
+	c := newFakeClient(
+		binding("kept", "developers", false),
+		binding("stale", "developers", false),
+		binding("unmanaged", "developers", true),
+		binding("other", "operators", false),
+	)
+	r := &ProjectAssignmentReconciler{Client: c}
+	keep := map[client.ObjectKey]bool{{Namespace: "p-abc12", Name: "kept"}: true}
+	if err := r.pruneAssignmentBindings(ctx, "developers", keep); err != nil {
+		t.Fatalf("pruneAssignmentBindings() error = %v", err)
+	}
+	for name, pruned := range bindings {
+		err := c.Get(ctx, client.ObjectKey{Namespace: "p-abc12", Name: name}, &managementv3.ProjectRoleTemplateBinding{})
+		if apierrors.IsNotFound(err) != pruned {
+			t.Errorf("binding %s pruned = %v, want %v", name, apierrors.IsNotFound(err), pruned)
+		}
+	}
+}
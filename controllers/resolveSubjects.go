@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+// userPrincipalIDField indexes Users by their principal IDs, which is how assignments name principals.
+const userPrincipalIDField = "principalId"
+
+// bindingSubject holds the subject fields shared by cluster and project role template bindings.
+type bindingSubject struct {
+	UserName           string
+	UserPrincipalName  string
+	GroupPrincipalName string
+}
+
+// resolveSubjects turns the subjects of an assignment into binding subjects, keeping their order. The principal
+// ID of a user is chosen by the preference of providers. Users that do not exist are left nil and reported in
+// unresolved, disabled users are left nil without being reported, so that their bindings are pruned. A principal
+// is bound together with the user it belongs to, or on its own until Rancher has created that user.
+func resolveSubjects(ctx context.Context, c client.Client, subjects []permissionsv1alpha1.Subject, providers []string) (resolved []*bindingSubject, unresolved []string, err error) {
+	resolved = make([]*bindingSubject, 0, len(subjects))
+	for _, subject := range subjects {
+		switch subject.Kind {
+		case permissionsv1alpha1.GroupSubject:
+			resolved = append(resolved, &bindingSubject{GroupPrincipalName: subject.Name})
+		case permissionsv1alpha1.PrincipalSubject:
+			user, err := findUser(ctx, c, subject.Name)
+			if err != nil {
+				return nil, nil, err
+			}
+			if user == nil {
+				resolved = append(resolved, &bindingSubject{UserPrincipalName: subject.Name})
+				continue
+			}
+			if isDisabled(user) {
+				globalLog.V(1).Info("Skipping principal of disabled user", "name", subject.Name, "user", user.Name)
+				resolved = append(resolved, nil)
+				continue
+			}
+			resolved = append(resolved, &bindingSubject{UserName: user.Name, UserPrincipalName: subject.Name})
+		default:
+			user, err := findUser(ctx, c, subject.Name)
+			if err != nil {
+				return nil, nil, err
+			}
+			if user == nil {
+				globalLog.Info("Skipping subject that cannot be resolved", "kind", subject.Kind, "name", subject.Name)
+				unresolved = append(unresolved, fmt.Sprintf("%s %q not found", subject.Kind, subject.Name))
+				resolved = append(resolved, nil)
+				continue
+			}
+			if isDisabled(user) {
+				globalLog.V(1).Info("Skipping disabled user", "name", subject.Name)
+				resolved = append(resolved, nil)
+				continue
+			}
+			resolved = append(resolved, &bindingSubject{UserName: user.Name, UserPrincipalName: preferredPrincipalID(user.PrincipalIDs, providers)})
+		}
+	}
+	return resolved, unresolved, nil
+}
+
+// findUser looks a user up by object name first and by Username, login name or principal ID second, through the
+// field indexes of the cache. It returns nil if none of them matches.
+func findUser(ctx context.Context, c client.Client, name string) (*managementv3.User, error) {
+	user := &managementv3.User{}
+	err := c.Get(ctx, client.ObjectKey{Name: name}, user)
+	if err == nil {
+		return user, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	var userList managementv3.UserList
+	if err := c.List(ctx, &userList, client.MatchingFields{userUsernameField: name}); err != nil {
+		return nil, err
+	}
+	if len(userList.Items) > 0 {
+		return &userList.Items[0], nil
+	}
+
+	// The index holds every login name an attribute records, the name must be the one derived for the user.
+	var attributeList managementv3.UserAttributeList
+	if err := c.List(ctx, &attributeList, client.MatchingFields{userAttributeLoginNameField: name}); err != nil {
+		return nil, err
+	}
+	for i := range attributeList.Items {
+		attribute := &attributeList.Items[i]
+		if err := c.Get(ctx, client.ObjectKey{Name: attribute.Name}, user); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if loginName(user, attribute) == name {
+			return user, nil
+		}
+	}
+
+	if err := c.List(ctx, &userList, client.MatchingFields{userPrincipalIDField: name}); err != nil {
+		return nil, err
+	}
+	if len(userList.Items) > 0 {
+		return &userList.Items[0], nil
+	}
+	return nil, nil
+}
+
+func indexUserPrincipalIDs(obj client.Object) []string {
+	user, ok := obj.(*managementv3.User)
+	if !ok {
+		return nil
+	}
+	return user.PrincipalIDs
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+func TestResolveSubjectsPrincipal(t *testing.T) {
+	disabled := false
+	c := newFakeClient(
+		&managementv3.User{ObjectMeta: metav1.ObjectMeta{Name: "u-abc12"}, PrincipalIDs: []string{"local://u-abc12", "openldap_user://uid=jdoe"}},
+		&managementv3.User{ObjectMeta: metav1.ObjectMeta{Name: "u-def34"}, PrincipalIDs: []string{"openldap_user://uid=left"}, Enabled: &disabled},
+	)
+	for _, tc := range []struct {
+		name      string
+		principal string
+		want      *bindingSubject
+	}{
+		{"principal of a user", "openldap_user://uid=jdoe", &bindingSubject{UserName: "u-abc12", UserPrincipalName: "openldap_user://uid=jdoe"}},
+		{"principal without a user yet", "openldap_user://uid=new", &bindingSubject{UserPrincipalName: "openldap_user://uid=new"}},
+		{"principal of a disabled user", "openldap_user://uid=left", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			subjects := []permissionsv1alpha1.Subject{{Kind: permissionsv1alpha1.PrincipalSubject, Name: tc.principal}}
+			resolved, unresolved, err := resolveSubjects(context.Background(), c, subjects, nil)
+			if err != nil {
+				t.Fatalf("resolveSubjects() error = %v", err)
+			}
+			if len(unresolved) > 0 {
+				t.Errorf("resolveSubjects() unresolved = %v, want none", unresolved)
+			}
+			if !reflect.DeepEqual(resolved, []*bindingSubject{tc.want}) {
+				t.Errorf("resolveSubjects() = %+v, want %+v", resolved[0], tc.want)
+			}
+		})
+	}
+}
+
+func TestFindUser(t *testing.T) {
+	c := newFakeClient(
+		&managementv3.User{ObjectMeta: metav1.ObjectMeta{Name: "u-abc12"}, Username: "jdoe", PrincipalIDs: []string{"local://u-abc12"}},
+		&managementv3.User{ObjectMeta: metav1.ObjectMeta{Name: "u-def34"}, PrincipalIDs: []string{"azuread_user://1234", "local://u-def34"}},
+		&managementv3.UserAttribute{
+			ObjectMeta:      metav1.ObjectMeta{Name: "u-def34"},
+			ExtraByProvider: map[string]map[string][]string{"azuread": {loginNameExtraKey: {"jsmith@example.com"}}, "github": {loginNameExtraKey: {"jsmith"}}},
+		},
+	)
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"u-abc12", "u-abc12"},
+		{"jdoe", "u-abc12"},
+		{"azuread:jsmith@example.com", "u-def34"},
+		{"azuread_user://1234", "u-def34"},
+		// The user has no github principal, so its login name is not derived from that provider.
+		{"github:jsmith", ""},
+		{"nobody", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			user, err := findUser(context.Background(), c, tc.name)
+			if err != nil {
+				t.Fatalf("findUser() error = %v", err)
+			}
+			got := ""
+			if user != nil {
+				got = user.Name
+			}
+			if got != tc.want {
+				t.Errorf("findUser() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
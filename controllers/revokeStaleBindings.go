@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"context"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// revokeStaleBindings deletes the ClusterRoleTemplateBindings the UserReconciler created for the user that are
+// not in desired, e.g. because a rule stopped selecting the user or the user no longer owns a cluster. The
+// bindings of held rules, see heldRoleMappingRules, are kept. Bindings that cannot be deleted are added to
+// failures under their cluster, the others are revoked regardless.
+func (r *UserReconciler) revokeStaleBindings(ctx context.Context, user *managementv3.User, desired map[client.ObjectKey]bool, held map[string]bool, failures clusterFailures) error {
+	var bindingList managementv3.ClusterRoleTemplateBindingList
+	if err := r.List(ctx, &bindingList, userBindings(user.Name)); err != nil {
+		return err
+	}
+
+	for i := range bindingList.Items {
+		binding := &bindingList.Items[i]
+		if !isUserRuleBinding(binding, user.Name) || desired[client.ObjectKeyFromObject(binding)] {
+			continue
+		}
+		if held[heldRuleKey(binding.Annotations[roleMappingAnnotation], binding.Labels[ruleLabel])] {
+			globalLog.V(1).Info("Keeping ClusterRoleTemplateBinding of invalid rule", "Name", binding.Name, "Namespace", binding.Namespace,
+				"rule", binding.Labels[ruleLabel], "roleMapping", binding.Annotations[roleMappingAnnotation])
+			continue
+		}
+		if err := deleteClusterRoleTemplateBinding(ctx, r.Client, binding); err != nil {
+			globalLog.Error(err, "Failed to revoke ClusterRoleTemplateBinding", "Name", binding.Name, "Namespace", binding.Namespace)
+			failures.add(binding.Namespace, "revoking %s: %s", binding.Name, err.Error())
+			continue
+		}
+		globalLog.Info("Revoked ClusterRoleTemplateBinding no longer desired for user", "Name", binding.Name, "Namespace", binding.Namespace,
+			"user", user.Name, "roleTemplate", binding.RoleTemplateName, "roleMapping", binding.Annotations[roleMappingAnnotation])
+	}
+	return nil
+}
+
+// isUserRuleBinding reports whether the binding was created by the UserReconciler for the named user and is
+// still managed. Bindings of ClusterAssignments carry the same labels and are left to their own reconciler.
+func isUserRuleBinding(binding *managementv3.ClusterRoleTemplateBinding, userName string) bool {
+	return binding.UserName == userName && isManaged(binding) &&
+		binding.Annotations[clusterAssignmentAnnotation] == ""
+}
+
+// userRuleBindingOwner returns the user a binding of the UserReconciler was created for.
+func userRuleBindingOwner(binding *managementv3.ClusterRoleTemplateBinding) string {
+	if binding.Annotations[clusterAssignmentAnnotation] != "" {
+		return ""
+	}
+	return binding.UserName
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+func TestRevokeStaleBindings(t *testing.T) {
+	ctx := context.Background()
+	user := &managementv3.User{ObjectMeta: metav1.ObjectMeta{Name: "u-abc12"}}
+	binding := func(name, clusterName, ruleName string, annotations map[string]string) *managementv3.ClusterRoleTemplateBinding {
+		if annotations == nil {
+			annotations = map[string]string{roleMappingAnnotation: "default"}
+		}
+		return &managementv3.ClusterRoleTemplateBinding{
+			ObjectMeta:       metav1.ObjectMeta{Name: name, Namespace: clusterName, Labels: managedLabels(user.Name, ruleName), Annotations: annotations},
+			RoleTemplateName: "cluster-owner",
+			UserName:         user.Name,
+			ClusterName:      clusterName,
+		}
+	}
+	legacy := binding("legacy", "c-abc12", "", map[string]string{legacyCreatedByAnnotation: legacyCreatedByAnnotationValue})
+	legacy.Labels = nil
+	otherUser := binding("other-user", "c-abc12", "owners", nil)
+	otherUser.UserName = "u-def34"
+	otherUser.Labels = managedLabels("u-def34", "owners")
+
+	t.Run("desired and stale", func(t *testing.T) {
+		bindings := []*managementv3.ClusterRoleTemplateBinding{
+			binding("desired", "c-abc12", "owners", nil),
+			binding("stale", "c-abc12", "admins", nil),
+			binding("held", "c-abc12", "typo", nil),
+			binding("unmanaged", "c-abc12", "admins", map[string]string{roleMappingAnnotation: "default", unmanagedAnnotation: "true"}),
+			binding("assignment", "c-abc12", "", map[string]string{clusterAssignmentAnnotation: "platform"}),
+			legacy,
+			otherUser,
+		}
+		objs := make([]client.Object, 0, len(bindings))
+		for _, b := range bindings {
+			objs = append(objs, b)
+		}
+		r := &UserReconciler{Client: newFakeClient(objs...)}
+		desired := map[client.ObjectKey]bool{{Namespace: "c-abc12", Name: "desired"}: true}
+		held := map[string]bool{heldRuleKey("default", "typo"): true}
+		failures := clusterFailures{}
+		if err := r.revokeStaleBindings(ctx, user, desired, held, failures); err != nil {
+			t.Fatalf("revokeStaleBindings() error = %v", err)
+		}
+		if len(failures) > 0 {
+			t.Errorf("failures = %v, want none", failures)
+		}
+		for _, b := range bindings {
+			err := r.Get(ctx, client.ObjectKeyFromObject(b), &managementv3.ClusterRoleTemplateBinding{})
+			if revoked := apierrors.IsNotFound(err); revoked != (b.Name == "stale") {
+				t.Errorf("binding %s revoked = %v, want %v", b.Name, revoked, b.Name == "stale")
+			}
+		}
+	})
+
+	t.Run("failed deletes are collected", func(t *testing.T) {
+		c := newFakeClient(binding("stale-1", "c-abc12", "admins", nil), binding("stale-2", "c-def34", "admins", nil), binding("stale-3", "c-def34", "auditors", nil))
+		r := &UserReconciler{Client: failingDeletes{c}}
+		failures := clusterFailures{}
+		if err := r.revokeStaleBindings(ctx, user, nil, nil, failures); err != nil {
+			t.Fatalf("revokeStaleBindings() error = %v", err)
+		}
+		if len(failures["c-abc12"]) != 1 || len(failures["c-def34"]) != 2 {
+			t.Errorf("failures = %v, want one for c-abc12 and two for c-def34", failures)
+		}
+	})
+}
+
+func TestHeldRoleMappingRules(t *testing.T) {
+	rule := func(name, substring, expression string) permissionsv1alpha1.RoleMappingRule {
+		return permissionsv1alpha1.RoleMappingRule{
+			Name:             name,
+			UserSelector:     permissionsv1alpha1.UserSelector{Substring: substring},
+			Expression:       expression,
+			RoleTemplateName: "cluster-owner",
+		}
+	}
+	deleted := metav1.Now()
+	mappings := []permissionsv1alpha1.RoleMapping{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Spec: permissionsv1alpha1.RoleMappingSpec{Rules: []permissionsv1alpha1.RoleMappingRule{
+			rule("owners", "owner", ""),
+			rule("broken", "admin", "user.username.startsWith("),
+		}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b"}, Spec: permissionsv1alpha1.RoleMappingSpec{Rules: []permissionsv1alpha1.RoleMappingRule{
+			rule("owners", "owner", ""),
+		}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "c", DeletionTimestamp: &deleted}, Spec: permissionsv1alpha1.RoleMappingSpec{Rules: []permissionsv1alpha1.RoleMappingRule{
+			rule("gone", "admin", "user.username.startsWith("),
+		}}},
+	}
+	rules, _ := collectRoleMappingRules(mappings)
+	held := heldRoleMappingRules(mappings, rules)
+	want := map[string]bool{heldRuleKey("a", "broken"): true, heldRuleKey("b", "owners"): true}
+	if len(held) != len(want) {
+		t.Errorf("heldRoleMappingRules() = %v, want %v", held, want)
+	}
+	for key := range want {
+		if !held[key] {
+			t.Errorf("heldRoleMappingRules() = %v, want %s held", held, key)
+		}
+	}
+}
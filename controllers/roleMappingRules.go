@@ -0,0 +1,295 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+// roleMappingAnnotation records the RoleMapping whose rule a binding was created for.
+const roleMappingAnnotation = "permissions.xddevelopment.com/rolemapping"
+
+// mappingRule is a rule together with the RoleMapping it comes from. MappingName is empty for the
+// built-in defaults and the rules of the role templates file.
+type mappingRule struct {
+	MappingName string
+	permissionsv1alpha1.RoleMappingRule
+	// matcher is compiled from the UserSelector of the rule. It is nil for invalid rules.
+	matcher Matcher
+	// expression is compiled from the Expression of the rule, if any.
+	expression cel.Program
+	// clusters is compiled from the Clusters of the rule, nil if it has none.
+	clusters *clusterScope
+}
+
+// newMappingRule compiles a rule, returning the problems that make it invalid.
+func newMappingRule(mappingName string, rule permissionsv1alpha1.RoleMappingRule) (mappingRule, []string) {
+	var matcher Matcher = allMatcher{}
+	var problems []string
+	switch {
+	case rule.Substring != "" || len(rule.Match) > 0:
+		matcher, problems = compileUserSelector(rule.UserSelector)
+	case rule.Expression == "":
+		problems = append(problems, "one of substring, match or expression is required")
+	}
+	var expression cel.Program
+	if rule.Expression != "" {
+		var err error
+		if expression, err = compileRuleExpression(rule.Expression); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid expression: %s", err.Error()))
+		}
+	}
+	// The rule name labels the bindings of the rule.
+	for _, problem := range validation.IsDNS1123Label(rule.Name) {
+		problems = append(problems, fmt.Sprintf("invalid name %q: %s", rule.Name, problem))
+	}
+	if rule.RoleTemplateName == "" {
+		problems = append(problems, "roleTemplateName is required")
+	}
+	clusters, clusterProblems := compileClusterScope(rule.Clusters, rule.BindTo)
+	problems = append(problems, clusterProblems...)
+	switch rule.BindTo {
+	case permissionsv1alpha1.UserTarget, "":
+	case permissionsv1alpha1.GroupTarget:
+		problems = append(problems, validateGroupRule(rule)...)
+	default:
+		problems = append(problems, fmt.Sprintf("unknown bindTo %q", rule.BindTo))
+	}
+	if len(problems) > 0 {
+		matcher = nil
+	}
+	return mappingRule{MappingName: mappingName, RoleMappingRule: rule, matcher: matcher, expression: expression, clusters: clusters}, problems
+}
+
+// validateGroupRule reports the problems specific to rules that bind groups. Such rules select groups rather
+// than users, so only the group attributes can be matched.
+func validateGroupRule(rule permissionsv1alpha1.RoleMappingRule) []string {
+	var problems []string
+	if rule.Substring != "" || rule.Expression != "" {
+		problems = append(problems, "substring and expression are not supported with bindTo Group")
+	}
+	if len(rule.Match) == 0 {
+		problems = append(problems, "match is required with bindTo Group")
+	}
+	for i, match := range rule.Match {
+		if match.Attribute != permissionsv1alpha1.GroupPrincipalIDAttribute && match.Attribute != permissionsv1alpha1.GroupNameAttribute {
+			problems = append(problems, fmt.Sprintf("match[%d]: attribute %s is not supported with bindTo Group", i, match.Attribute))
+		}
+	}
+	if rule.Clusters == nil || (len(rule.Clusters.Names) == 0 && !hasClusterCriteria(rule.Clusters)) {
+		problems = append(problems, "clusters is required with bindTo Group")
+	}
+	return problems
+}
+
+// bindsGroups reports whether the rule creates group bindings rather than user bindings.
+func (r mappingRule) bindsGroups() bool {
+	return r.BindTo == permissionsv1alpha1.GroupTarget
+}
+
+// matchesGroup reports whether a group rule selects the group.
+func (r mappingRule) matchesGroup(group managementv3.Principal) bool {
+	return r.matcher != nil && r.matcher.Match(&managementv3.User{}, []managementv3.Principal{group})
+}
+
+// defaultRoleMappingRules are used while no RoleMapping exists and no role templates file is mounted.
+func defaultRoleMappingRules() []mappingRule {
+	defaults := []struct {
+		substring    string
+		roleTemplate string
+	}{
+		{"cluster-admin", "cluster-admin"},
+		{"cluster-auditor", "read-only"},
+		{"developer", "projects-create"},
+	}
+
+	rules := make([]mappingRule, 0, len(defaults))
+	for _, d := range defaults {
+		rule, _ := newMappingRule("", permissionsv1alpha1.RoleMappingRule{
+			Name:             d.substring,
+			UserSelector:     permissionsv1alpha1.UserSelector{Substring: d.substring},
+			RoleTemplateName: d.roleTemplate,
+		})
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// loadRoleMappingRules returns the valid rules of all RoleMappings, and the held ones, see heldRoleMappingRules.
+// While there are no RoleMappings, it falls back to the role templates file and then to the built-in defaults,
+// neither of which holds rules. A role templates file that exists but cannot be loaded is an error rather than a
+// reason to use the defaults.
+func loadRoleMappingRules(ctx context.Context, c client.Client, file *RoleTemplatesFile) ([]mappingRule, map[string]bool, error) {
+	var mappingList permissionsv1alpha1.RoleMappingList
+	if err := c.List(ctx, &mappingList); err != nil {
+		return nil, nil, err
+	}
+	rules, _ := collectRoleMappingRules(mappingList.Items)
+	if len(mappingList.Items) > 0 {
+		return rules, heldRoleMappingRules(mappingList.Items, rules), nil
+	}
+
+	if file != nil {
+		rules, found, err := file.Rules(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if found {
+			return rules, map[string]bool{}, nil
+		}
+	}
+	globalLog.V(1).Info("No RoleMapping and no external role templates file found. Using defaults.")
+	return defaultRoleMappingRules(), map[string]bool{}, nil
+}
+
+// collectRoleMappingRules returns the valid rules of the mappings, and the errors of the invalid ones keyed
+// by mapping name. Rule names must be unique across all mappings since they end up in binding names; on a
+// clash the rule of the mapping that sorts first by name wins.
+func collectRoleMappingRules(mappings []permissionsv1alpha1.RoleMapping) ([]mappingRule, map[string][]string) {
+	forgetDeletedRoleMappings(mappings)
+	sorted := append([]permissionsv1alpha1.RoleMapping(nil), mappings...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	var rules []mappingRule
+	errs := map[string][]string{}
+	owners := map[string]string{}
+	for _, mapping := range sorted {
+		if mapping.DeletionTimestamp != nil {
+			continue
+		}
+		for i, compiled := range compiledRoleMappingRules(&mapping) {
+			rule, problems := compiled.mappingRule, compiled.problems
+			if len(problems) > 0 {
+				for _, problem := range problems {
+					errs[mapping.Name] = append(errs[mapping.Name], fmt.Sprintf("rules[%d]: %s", i, problem))
+				}
+				continue
+			}
+			if owner, ok := owners[rule.Name]; ok {
+				errs[mapping.Name] = append(errs[mapping.Name], fmt.Sprintf("rules[%d]: rule name %q is already used by RoleMapping %s", i, rule.Name, owner))
+				continue
+			}
+			owners[rule.Name] = mapping.Name
+			rules = append(rules, rule)
+		}
+	}
+	return rules, errs
+}
+
+// compiledRule is a rule of a RoleMapping as newMappingRule compiles it.
+type compiledRule struct {
+	mappingRule
+	problems []string
+}
+
+// compiledMapping holds the compiled rules of one generation of a RoleMapping.
+type compiledMapping struct {
+	generation int64
+	rules      []compiledRule
+}
+
+// roleMappingRuleCache keeps the compiled rules of every RoleMapping by UID, so that expressions and patterns
+// are compiled once per generation of a mapping rather than on every reconcile and event. Entries of deleted
+// mappings are dropped by collectRoleMappingRules, which sees all mappings.
+var roleMappingRuleCache = struct {
+	sync.Mutex
+	mappings map[types.UID]compiledMapping
+}{mappings: map[types.UID]compiledMapping{}}
+
+// compiledRoleMappingRules returns the rules of the mapping compiled, in the order of its spec. The generation of
+// a RoleMapping changes with its spec, so the cached rules are those of the spec as long as it matches. Mappings
+// without a UID, which the API server always sets, are compiled without caching.
+func compiledRoleMappingRules(mapping *permissionsv1alpha1.RoleMapping) []compiledRule {
+	if mapping.UID != "" {
+		roleMappingRuleCache.Lock()
+		cached, ok := roleMappingRuleCache.mappings[mapping.UID]
+		roleMappingRuleCache.Unlock()
+		if ok && cached.generation == mapping.Generation {
+			return cached.rules
+		}
+	}
+
+	rules := make([]compiledRule, 0, len(mapping.Spec.Rules))
+	for _, rule := range mapping.Spec.Rules {
+		compiled, problems := newMappingRule(mapping.Name, rule)
+		rules = append(rules, compiledRule{mappingRule: compiled, problems: problems})
+	}
+	if mapping.UID != "" {
+		roleMappingRuleCache.Lock()
+		roleMappingRuleCache.mappings[mapping.UID] = compiledMapping{generation: mapping.Generation, rules: rules}
+		roleMappingRuleCache.Unlock()
+	}
+	return rules
+}
+
+// forgetDeletedRoleMappings drops the cached rules of the mappings that are not among the given ones.
+func forgetDeletedRoleMappings(mappings []permissionsv1alpha1.RoleMapping) {
+	present := make(map[types.UID]bool, len(mappings))
+	for i := range mappings {
+		present[mappings[i].UID] = true
+	}
+	roleMappingRuleCache.Lock()
+	defer roleMappingRuleCache.Unlock()
+	for uid := range roleMappingRuleCache.mappings {
+		if !present[uid] {
+			delete(roleMappingRuleCache.mappings, uid)
+		}
+	}
+}
+
+// heldRoleMappingRules returns the rules that are present in one of the mappings but failed validation, e.g.
+// because of a typo in an expression or a name already used by another mapping, keyed by heldRuleKey. Their
+// bindings are kept rather than revoked: a bad edit of a RoleMapping must not take access away, only removing
+// the rule, or the mapping, does.
+func heldRoleMappingRules(mappings []permissionsv1alpha1.RoleMapping, rules []mappingRule) map[string]bool {
+	active := map[string]bool{}
+	for _, rule := range rules {
+		active[heldRuleKey(rule.MappingName, rule.Name)] = true
+	}
+	held := map[string]bool{}
+	for _, mapping := range mappings {
+		if mapping.DeletionTimestamp != nil {
+			continue
+		}
+		for _, rule := range mapping.Spec.Rules {
+			if key := heldRuleKey(mapping.Name, rule.Name); rule.Name != "" && !active[key] {
+				held[key] = true
+			}
+		}
+	}
+	return held
+}
+
+// heldRuleKey identifies a rule of a mapping the way its bindings record it, by the roleMapping annotation and
+// the rule label.
+func heldRuleKey(mappingName, ruleName string) string {
+	return mappingName + "/" + ruleName
+}
+
+// matchesUser reports whether the rule selects the user, a member of groups.
+func (r mappingRule) matchesUser(user *managementv3.User, groups []managementv3.Principal) bool {
+	return r.matcher != nil && r.matcher.Match(user, groups)
+}
+
+// matchesCluster reports whether the expression of the rule, if any, holds for the user on the cluster. An
+// expression that fails to evaluate, e.g. because it reads a missing label, does not match.
+func (r mappingRule) matchesCluster(user *managementv3.User, groups []managementv3.Principal, cluster *managementv3.Cluster) bool {
+	if r.expression == nil {
+		return true
+	}
+	matched, err := evaluateRuleExpression(r.expression, user, groups, cluster)
+	if err != nil {
+		globalLog.Info("Failed to evaluate rule expression", "rule", r.Name, "user", user.Name, "cluster", cluster.Name, "error", err.Error())
+		return false
+	}
+	return matched
+}
@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+func TestCompiledRoleMappingRules(t *testing.T) {
+	mapping := &permissionsv1alpha1.RoleMapping{
+		ObjectMeta: metav1.ObjectMeta{Name: "expressions", UID: "7d5c1a5e", Generation: 1},
+		Spec: permissionsv1alpha1.RoleMappingSpec{Rules: []permissionsv1alpha1.RoleMappingRule{{
+			Name:             "owners",
+			Expression:       `cluster.labels["owner"] == user.username`,
+			RoleTemplateName: "cluster-owner",
+		}}},
+	}
+
+	first := compiledRoleMappingRules(mapping)
+	if len(first) != 1 || len(first[0].problems) > 0 || first[0].expression == nil {
+		t.Fatalf("compiledRoleMappingRules() = %+v, want one valid rule", first)
+	}
+	if again := compiledRoleMappingRules(mapping.DeepCopy()); &again[0] != &first[0] {
+		t.Errorf("compiledRoleMappingRules() compiled the same generation again")
+	}
+
+	mapping.Generation = 2
+	mapping.Spec.Rules[0].Expression = "cluster.labels["
+	second := compiledRoleMappingRules(mapping)
+	if len(second) != 1 || len(second[0].problems) == 0 {
+		t.Errorf("compiledRoleMappingRules() = %+v, want the invalid expression of the new generation", second)
+	}
+
+	collectRoleMappingRules(nil)
+	roleMappingRuleCache.Lock()
+	_, cached := roleMappingRuleCache.mappings[mapping.UID]
+	roleMappingRuleCache.Unlock()
+	if cached {
+		t.Errorf("collectRoleMappingRules() kept the rules of a deleted RoleMapping")
+	}
+}
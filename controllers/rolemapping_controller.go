@@ -0,0 +1,309 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+// roleMappingFinalizer keeps a RoleMapping around until the bindings of its Group rules are deleted.
+const roleMappingFinalizer = "permissions.xddevelopment.com/rolemapping"
+
+// RoleMappingReconciler reconciles a RoleMapping object. It validates the mapping, reports the outcome in its
+// status and manages the bindings of its Group rules; the User rules are applied by the UserReconciler.
+type RoleMappingReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Recorder records the replacement of bindings whose immutable fields changed.
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=permissions.xddevelopment.com,resources=rolemappings,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=permissions.xddevelopment.com,resources=rolemappings/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=permissions.xddevelopment.com,resources=rolemappings/finalizers,verbs=update
+//+kubebuilder:rbac:groups=management.cattle.io,resources=roletemplates,verbs=get;list;watch
+//+kubebuilder:rbac:groups=management.cattle.io,resources=userattributes,verbs=get;list;watch
+
+func (r *RoleMappingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	mapping := &permissionsv1alpha1.RoleMapping{}
+	if err := r.Get(ctx, req.NamespacedName, mapping); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if mapping.DeletionTimestamp != nil {
+		existing, err := r.listGroupBindings(ctx, mapping.Name)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.pruneGroupBindings(ctx, mapping.Name, existing, nil, nil); err != nil {
+			return ctrl.Result{}, err
+		}
+		if controllerutil.RemoveFinalizer(mapping, roleMappingFinalizer) {
+			if err := r.Update(ctx, mapping); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if controllerutil.AddFinalizer(mapping, roleMappingFinalizer) {
+		if err := r.Update(ctx, mapping); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	var mappingList permissionsv1alpha1.RoleMappingList
+	if err := r.List(ctx, &mappingList); err != nil {
+		return ctrl.Result{}, err
+	}
+	rules, ruleErrors := collectRoleMappingRules(mappingList.Items)
+
+	status := permissionsv1alpha1.RoleMappingStatus{
+		ObservedGeneration: mapping.Generation,
+		Conditions:         append([]metav1.Condition(nil), mapping.Status.Conditions...),
+		Errors:             ruleErrors[mapping.Name],
+	}
+
+	// Report the rules that refer to role templates Rancher does not know about, or would not bind.
+	var failures []string
+	check := newRoleTemplateCheck(r.Client, clusterRoleContext)
+	for i, rule := range mapping.Spec.Rules {
+		if !isActiveRule(rules, mapping.Name, rule.Name) {
+			continue
+		}
+		problem, err := check.problem(ctx, rule.RoleTemplateName)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if problem != "" {
+			failures = append(failures, fmt.Sprintf("rules[%d]: %s", i, problem))
+		}
+	}
+	status.Errors = append(status.Errors, failures...)
+
+	groupBindings, groupFailures, err := r.applyGroupRules(ctx, mapping.Name, rules, heldRoleMappingRules(mappingList.Items, rules), check)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	status.GroupBindings = groupBindings
+	status.Errors = append(status.Errors, groupFailures...)
+	failures = append(failures, groupFailures...)
+
+	var invalidSpec error
+	if problems := ruleErrors[mapping.Name]; len(problems) > 0 {
+		invalidSpec = errors.New(strings.Join(problems, "; "))
+	}
+	setStatusConditions(&status.Conditions, mapping.Generation, invalidSpec, failures)
+	if !equality.Semantic.DeepEqual(mapping.Status, status) {
+		mapping.Status = status
+		if err := r.Status().Update(ctx, mapping); err != nil {
+			globalLog.Error(err, "Failed to update RoleMapping status", "roleMapping", mapping.Name)
+			return ctrl.Result{}, err
+		}
+	}
+	if len(groupFailures) > 0 {
+		return ctrl.Result{}, fmt.Errorf("RoleMapping %s is degraded: %d group binding failure(s)", mapping.Name, len(groupFailures))
+	}
+	return ctrl.Result{}, nil
+}
+
+// applyGroupRules creates a ClusterRoleTemplateBinding for every group a Group rule of the mapping selects, on
+// every cluster of the rule, and deletes the group bindings created earlier for the mapping that are no longer
+// part of that set, e.g. because the rule was removed or no user is a member of the group anymore. Rules whose
+// role template fails check create no bindings, but keep those they created before, and so do held rules.
+func (r *RoleMappingReconciler) applyGroupRules(ctx context.Context, mappingName string, rules []mappingRule, held map[string]bool, check *roleTemplateCheck) (bindings []string, failures []string, err error) {
+	groups, err := knownGroups(ctx, r.Client)
+	if err != nil {
+		return nil, nil, err
+	}
+	var clusterList managementv3.ClusterList
+	if err := r.List(ctx, &clusterList); err != nil {
+		return nil, nil, err
+	}
+	existing, err := r.listGroupBindings(ctx, mappingName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	desired := map[client.ObjectKey]bool{}
+	for _, rule := range rules {
+		if rule.MappingName != mappingName || !rule.bindsGroups() {
+			continue
+		}
+		// The problem is reported with the rule already.
+		problem, err := check.problem(ctx, rule.RoleTemplateName)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Group rules cannot select clusters by ownership, so the clusters do not depend on a user.
+		clusters := determineClustersForUser(clusterList.Items, rule.clusters, &managementv3.User{}, nil)
+		for _, group := range groups {
+			if !rule.matchesGroup(group) {
+				continue
+			}
+			subject := permissionsv1alpha1.Subject{Kind: permissionsv1alpha1.GroupSubject, Name: group.Name}
+			for i := range clusterList.Items {
+				clusterName := clusterList.Items[i].Name
+				if _, ok := clusters[clusterName]; !ok {
+					continue
+				}
+				binding := &managementv3.ClusterRoleTemplateBinding{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      assignmentBindingName(mappingName, clusterName, subject, rule.RoleTemplateName),
+						Namespace: clusterName,
+						Labels:    managedLabels("", rule.Name),
+						Annotations: map[string]string{
+							roleMappingAnnotation: mappingName,
+						},
+					},
+					GroupPrincipalName: group.Name,
+					ClusterName:        clusterName,
+					RoleTemplateName:   rule.RoleTemplateName,
+				}
+
+				// Keep failed bindings out of pruning, an existing binding must survive a failed update.
+				key := client.ObjectKeyFromObject(binding)
+				if desired[key] {
+					continue
+				}
+				desired[key] = true
+				if problem != "" {
+					continue
+				}
+				if err := applyClusterRoleTemplateBinding(ctx, r.Client, r.Recorder, binding); err != nil {
+					failures = append(failures, fmt.Sprintf("rule %s: group %s on cluster %s: %s", rule.Name, group.Name, clusterName, err.Error()))
+					continue
+				}
+				// The binding may have been replaced under another name.
+				key = client.ObjectKeyFromObject(binding)
+				desired[key] = true
+				bindings = append(bindings, key.String())
+			}
+		}
+	}
+
+	if err := r.pruneGroupBindings(ctx, mappingName, existing, desired, held); err != nil {
+		failures = append(failures, err.Error())
+	}
+	sort.Strings(bindings)
+	return bindings, failures, nil
+}
+
+// listGroupBindings returns the group bindings created for the named mapping, through the group principal
+// index of the cache. Bindings of users created by the UserReconciler carry the same annotation but no group
+// principal, and are not indexed.
+func (r *RoleMappingReconciler) listGroupBindings(ctx context.Context, mappingName string) ([]*managementv3.ClusterRoleTemplateBinding, error) {
+	var bindingList managementv3.ClusterRoleTemplateBindingList
+	if err := r.List(ctx, &bindingList, client.MatchingFields{bindingGroupPrincipalNameField: anyGroupPrincipal}); err != nil {
+		return nil, err
+	}
+
+	var bindings []*managementv3.ClusterRoleTemplateBinding
+	for i := range bindingList.Items {
+		binding := &bindingList.Items[i]
+		if binding.Annotations[roleMappingAnnotation] == mappingName && isManaged(binding) {
+			bindings = append(bindings, binding)
+		}
+	}
+	return bindings, nil
+}
+
+// pruneGroupBindings deletes the existing group bindings of the named mapping that are neither in keep nor
+// created for a held rule, see heldRoleMappingRules.
+func (r *RoleMappingReconciler) pruneGroupBindings(ctx context.Context, mappingName string, existing []*managementv3.ClusterRoleTemplateBinding, keep map[client.ObjectKey]bool, held map[string]bool) error {
+	for _, binding := range existing {
+		if keep[client.ObjectKeyFromObject(binding)] || held[heldRuleKey(mappingName, binding.Labels[ruleLabel])] {
+			continue
+		}
+		if err := deleteClusterRoleTemplateBinding(ctx, r.Client, binding); err != nil {
+			globalLog.Error(err, "Failed to delete ClusterRoleTemplateBinding", "name", binding.Name, "namespace", binding.Namespace)
+			return err
+		}
+		globalLog.Info("Deleted group ClusterRoleTemplateBinding no longer covered by RoleMapping", "name", binding.Name, "namespace", binding.Namespace, "roleMapping", mappingName)
+	}
+	return nil
+}
+
+// knownGroups returns the group principals of all users, deduplicated by principal ID and sorted by it.
+func knownGroups(ctx context.Context, c client.Client) ([]managementv3.Principal, error) {
+	userGroups, err := allUserGroupPrincipals(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var groups []managementv3.Principal
+	for _, memberOf := range userGroups {
+		for _, group := range memberOf {
+			if !seen[group.Name] {
+				seen[group.Name] = true
+				groups = append(groups, group)
+			}
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+// isActiveRule reports whether the named rule of the mapping passed validation.
+func isActiveRule(rules []mappingRule, mappingName, ruleName string) bool {
+	for _, rule := range rules {
+		if rule.MappingName == mappingName && rule.Name == ruleName {
+			return true
+		}
+	}
+	return false
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RoleMappingReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&permissionsv1alpha1.RoleMapping{}).
+		// Rule names are unique across mappings, so a change to one mapping can invalidate another.
+		Watches(&source.Kind{Type: &permissionsv1alpha1.RoleMapping{}}, handler.EnqueueRequestsFromMapFunc(r.allRoleMappings)).
+		Watches(&source.Kind{Type: &managementv3.RoleTemplate{}}, handler.EnqueueRequestsFromMapFunc(r.allRoleMappings), builder.WithPredicates(roleTemplateValidityChanged)).
+		// Group rules bind the groups known from UserAttributes on existing clusters.
+		Watches(&source.Kind{Type: &managementv3.UserAttribute{}}, handler.EnqueueRequestsFromMapFunc(r.allRoleMappings), builder.WithPredicates(groupsRefreshed)).
+		// Rancher updates the status of clusters all the time, only changes that can select them matter.
+		Watches(&source.Kind{Type: &managementv3.Cluster{}}, handler.EnqueueRequestsFromMapFunc(r.allRoleMappings), builder.WithPredicates(clusterSelectionChanged)).
+		Watches(&source.Kind{Type: &managementv3.ClusterRoleTemplateBinding{}}, enqueueBindingOwner(groupBindingOwner), builder.WithPredicates(bindingDrift(groupBindingOwner, r.Recorder))).
+		Complete(r)
+}
+
+// groupBindingOwner returns the RoleMapping a group binding was created for.
+func groupBindingOwner(binding *managementv3.ClusterRoleTemplateBinding) string {
+	if binding.GroupPrincipalName == "" || binding.Annotations[clusterAssignmentAnnotation] != "" {
+		return ""
+	}
+	return binding.Annotations[roleMappingAnnotation]
+}
+
+// allRoleMappings requeues every RoleMapping.
+func (r *RoleMappingReconciler) allRoleMappings(_ client.Object) []reconcile.Request {
+	var mappingList permissionsv1alpha1.RoleMappingList
+	if err := r.List(context.Background(), &mappingList); err != nil {
+		globalLog.Error(err, "Failed to list RoleMappings")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(mappingList.Items))
+	for _, mapping := range mappingList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&mapping)})
+	}
+	return requests
+}
@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+func TestRoleMappingGroupRules(t *testing.T) {
+	ctx := context.Background()
+	group := func(id, displayName string) managementv3.Principal {
+		return managementv3.Principal{ObjectMeta: metav1.ObjectMeta{Name: id}, DisplayName: displayName, Provider: "azuread"}
+	}
+	attribute := &managementv3.UserAttribute{
+		ObjectMeta: metav1.ObjectMeta{Name: "u-abc12"},
+		GroupPrincipals: map[string]managementv3.Principals{"azuread": {Items: []managementv3.Principal{
+			group("azuread_group://1", "platform-eu"),
+			group("azuread_group://2", "platform-us"),
+			group("azuread_group://3", "sales"),
+		}}},
+	}
+	mapping := &permissionsv1alpha1.RoleMapping{
+		ObjectMeta: metav1.ObjectMeta{Name: "groups"},
+		Spec: permissionsv1alpha1.RoleMappingSpec{Rules: []permissionsv1alpha1.RoleMappingRule{{
+			Name: "platform",
+			UserSelector: permissionsv1alpha1.UserSelector{Match: []permissionsv1alpha1.AttributeMatch{
+				{Attribute: permissionsv1alpha1.GroupNameAttribute, Operator: permissionsv1alpha1.PrefixOperator, Value: "platform-"},
+			}},
+			RoleTemplateName: "cluster-member",
+			BindTo:           permissionsv1alpha1.GroupTarget,
+			Clusters:         &permissionsv1alpha1.ClusterScope{Names: []string{"c-abc12", "c-def34"}},
+		}}},
+	}
+	c := newFakeClient(
+		mapping,
+		attribute,
+		&managementv3.RoleTemplate{ObjectMeta: metav1.ObjectMeta{Name: "cluster-member"}, Context: clusterRoleContext},
+		&managementv3.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "c-abc12"}},
+		&managementv3.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "c-def34"}},
+		&managementv3.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "c-ghi56"}},
+	)
+	r := &RoleMappingReconciler{Client: c}
+	reconcile := func() {
+		t.Helper()
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(mapping)}); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+	}
+	groupBindings := func() []string {
+		t.Helper()
+		var bindingList managementv3.ClusterRoleTemplateBindingList
+		if err := c.List(ctx, &bindingList); err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		var bindings []string
+		for _, binding := range bindingList.Items {
+			if binding.Annotations[roleMappingAnnotation] != mapping.Name || binding.Labels[ruleLabel] != "platform" || binding.RoleTemplateName != "cluster-member" {
+				t.Errorf("binding %s/%s = %+v, want a cluster-member binding of rule platform", binding.Namespace, binding.Name, binding)
+			}
+			bindings = append(bindings, binding.GroupPrincipalName+" on "+binding.ClusterName)
+		}
+		sort.Strings(bindings)
+		return bindings
+	}
+
+	t.Run("one binding per group and cluster", func(t *testing.T) {
+		reconcile()
+		want := []string{
+			"azuread_group://1 on c-abc12", "azuread_group://1 on c-def34",
+			"azuread_group://2 on c-abc12", "azuread_group://2 on c-def34",
+		}
+		if got := groupBindings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("group bindings = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("group disappears", func(t *testing.T) {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(attribute), attribute); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		attribute.GroupPrincipals["azuread"] = managementv3.Principals{Items: []managementv3.Principal{group("azuread_group://1", "platform-eu")}}
+		if err := c.Update(ctx, attribute); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		reconcile()
+		want := []string{"azuread_group://1 on c-abc12", "azuread_group://1 on c-def34"}
+		if got := groupBindings(); !reflect.DeepEqual(got, want) {
+			t.Errorf("group bindings = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("rule removed", func(t *testing.T) {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(mapping), mapping); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		mapping.Spec.Rules = nil
+		if err := c.Update(ctx, mapping); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+		reconcile()
+		if got := groupBindings(); len(got) > 0 {
+			t.Errorf("group bindings = %v, want none", got)
+		}
+	})
+}
+
+var _ = Describe("RoleMapping controller", func() {
+	ctx := context.Background()
+	mappingStatus := func(name string) func() permissionsv1alpha1.RoleMappingStatus {
+		return func() permissionsv1alpha1.RoleMappingStatus {
+			mapping := &permissionsv1alpha1.RoleMapping{}
+			Expect(k8sClient.Get(ctx, client.ObjectKey{Name: name}, mapping)).To(Succeed())
+			return mapping.Status
+		}
+	}
+	conditionStatus := func(conditionType string) func(permissionsv1alpha1.RoleMappingStatus) metav1.ConditionStatus {
+		return func(status permissionsv1alpha1.RoleMappingStatus) metav1.ConditionStatus {
+			return conditionOf(status.Conditions, conditionType).Status
+		}
+	}
+
+	It("binds the groups of Group rules on their clusters and reports Ready", func() {
+		createCluster(ctx, "c-envrm1")
+		createRoleTemplate(ctx, "envtest-rm-member", clusterRoleContext, false)
+		Expect(k8sClient.Create(ctx, &managementv3.UserAttribute{
+			ObjectMeta: metav1.ObjectMeta{Name: "u-envrm1"},
+			GroupPrincipals: map[string]managementv3.Principals{"azuread": {Items: []managementv3.Principal{
+				{ObjectMeta: metav1.ObjectMeta{Name: "azuread_group://envtest-rm-1"}, DisplayName: "envtest-platform-eu", Provider: "azuread"},
+				{ObjectMeta: metav1.ObjectMeta{Name: "azuread_group://envtest-rm-2"}, DisplayName: "envtest-sales", Provider: "azuread"},
+			}}},
+		})).To(Succeed())
+		Expect(k8sClient.Create(ctx, &permissionsv1alpha1.RoleMapping{
+			ObjectMeta: metav1.ObjectMeta{Name: "envtest-groups"},
+			Spec: permissionsv1alpha1.RoleMappingSpec{Rules: []permissionsv1alpha1.RoleMappingRule{{
+				Name: "envtest-platform",
+				UserSelector: permissionsv1alpha1.UserSelector{Match: []permissionsv1alpha1.AttributeMatch{
+					{Attribute: permissionsv1alpha1.GroupNameAttribute, Operator: permissionsv1alpha1.PrefixOperator, Value: "envtest-platform-"},
+				}},
+				RoleTemplateName: "envtest-rm-member",
+				BindTo:           permissionsv1alpha1.GroupTarget,
+				Clusters:         &permissionsv1alpha1.ClusterScope{Names: []string{"c-envrm1"}},
+			}}},
+		})).To(Succeed())
+
+		Eventually(func() []managementv3.ClusterRoleTemplateBinding {
+			return clusterBindingsFor(ctx, "c-envrm1", roleMappingAnnotation, "envtest-groups")
+		}, envtestTimeout, envtestInterval).Should(ConsistOf(And(
+			HaveField("GroupPrincipalName", "azuread_group://envtest-rm-1"),
+			HaveField("RoleTemplateName", "envtest-rm-member"),
+		)))
+		Eventually(mappingStatus("envtest-groups"), envtestTimeout, envtestInterval).Should(And(
+			WithTransform(conditionStatus(permissionsv1alpha1.ConditionReady), Equal(metav1.ConditionTrue)),
+			HaveField("GroupBindings", HaveLen(1)),
+			HaveField("Errors", BeEmpty()),
+		))
+	})
+
+	It("reports a rule with an invalid expression as an invalid spec", func() {
+		Expect(k8sClient.Create(ctx, &permissionsv1alpha1.RoleMapping{
+			ObjectMeta: metav1.ObjectMeta{Name: "envtest-invalid"},
+			Spec: permissionsv1alpha1.RoleMappingSpec{Rules: []permissionsv1alpha1.RoleMappingRule{{
+				Name:             "envtest-broken",
+				Expression:       "user.username ==",
+				RoleTemplateName: "envtest-rm-member",
+			}}},
+		})).To(Succeed())
+
+		Eventually(mappingStatus("envtest-invalid"), envtestTimeout, envtestInterval).Should(And(
+			WithTransform(conditionStatus(permissionsv1alpha1.ConditionInvalidSpec), Equal(metav1.ConditionTrue)),
+			WithTransform(conditionStatus(permissionsv1alpha1.ConditionReady), Equal(metav1.ConditionFalse)),
+			HaveField("Errors", ContainElement(ContainSubstring("rules[0]: invalid expression"))),
+		))
+	})
+
+	It("reports a rule whose role template does not exist as Degraded", func() {
+		Expect(k8sClient.Create(ctx, &permissionsv1alpha1.RoleMapping{
+			ObjectMeta: metav1.ObjectMeta{Name: "envtest-missing"},
+			Spec: permissionsv1alpha1.RoleMappingSpec{Rules: []permissionsv1alpha1.RoleMappingRule{{
+				Name:             "envtest-auditors",
+				UserSelector:     permissionsv1alpha1.UserSelector{Substring: "envtest-auditor"},
+				RoleTemplateName: "envtest-rm-missing",
+			}}},
+		})).To(Succeed())
+
+		Eventually(func() metav1.Condition {
+			return conditionOf(mappingStatus("envtest-missing")().Conditions, permissionsv1alpha1.ConditionDegraded)
+		}, envtestTimeout, envtestInterval).Should(And(
+			HaveField("Status", metav1.ConditionTrue),
+			HaveField("Message", ContainSubstring(`role template "envtest-rm-missing" not found`)),
+		))
+	})
+})
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+)
+
+// ruleExpressionEnv declares the variables a rule expression can use. They are qualified names rather than
+// fields of a map, so that a misspelled attribute is a compile error instead of a runtime one.
+var ruleExpressionEnv = mustRuleExpressionEnv()
+
+func mustRuleExpressionEnv() *cel.Env {
+	stringMap := cel.MapType(cel.StringType, cel.StringType)
+	stringList := cel.ListType(cel.StringType)
+	env, err := cel.NewEnv(
+		cel.Variable("user.name", cel.StringType),
+		cel.Variable("user.username", cel.StringType),
+		cel.Variable("user.displayName", cel.StringType),
+		cel.Variable("user.principalIds", stringList),
+		cel.Variable("user.labels", stringMap),
+		cel.Variable("user.annotations", stringMap),
+		cel.Variable("groups", stringList),
+		cel.Variable("groupNames", stringList),
+		cel.Variable("cluster.name", cel.StringType),
+		cel.Variable("cluster.displayName", cel.StringType),
+		cel.Variable("cluster.provider", cel.StringType),
+		cel.Variable("cluster.driver", cel.StringType),
+		cel.Variable("cluster.fleetWorkspaceName", cel.StringType),
+		cel.Variable("cluster.labels", stringMap),
+		cel.Variable("cluster.annotations", stringMap),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return env
+}
+
+// compileRuleExpression parses and type-checks a rule expression, which must evaluate to a bool.
+func compileRuleExpression(expression string) (cel.Program, error) {
+	ast, issues := ruleExpressionEnv.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if !ast.OutputType().IsAssignableType(cel.BoolType) {
+		return nil, fmt.Errorf("expression must evaluate to a bool, not %s", ast.OutputType())
+	}
+	return ruleExpressionEnv.Program(ast)
+}
+
+// evaluateRuleExpression evaluates a compiled rule expression for a user, its group principals and a candidate
+// cluster.
+func evaluateRuleExpression(program cel.Program, user *managementv3.User, groups []managementv3.Principal, cluster *managementv3.Cluster) (bool, error) {
+	groupIDs := make([]string, 0, len(groups))
+	groupNames := make([]string, 0, len(groups))
+	for _, group := range groups {
+		groupIDs = append(groupIDs, group.Name)
+		groupNames = append(groupNames, group.DisplayName)
+	}
+
+	out, _, err := program.Eval(map[string]interface{}{
+		"user.name":                  user.Name,
+		"user.username":              user.Username,
+		"user.displayName":           user.DisplayName,
+		"user.principalIds":          nonNilStrings(user.PrincipalIDs),
+		"user.labels":                nonNilMap(user.Labels),
+		"user.annotations":           nonNilMap(user.Annotations),
+		"groups":                     groupIDs,
+		"groupNames":                 groupNames,
+		"cluster.name":               cluster.Name,
+		"cluster.displayName":        cluster.Spec.DisplayName,
+		"cluster.provider":           cluster.Status.Provider,
+		"cluster.driver":             cluster.Status.Driver,
+		"cluster.fleetWorkspaceName": cluster.Spec.FleetWorkspaceName,
+		"cluster.labels":             nonNilMap(cluster.Labels),
+		"cluster.annotations":        nonNilMap(cluster.Annotations),
+	})
+	if err != nil {
+		return false, err
+	}
+	matched, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression evaluated to %v instead of a bool", out.Value())
+	}
+	return matched, nil
+}
+
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func nonNilMap(values map[string]string) map[string]string {
+	if values == nil {
+		return map[string]string{}
+	}
+	return values
+}
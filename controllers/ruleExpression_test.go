@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRuleExpression(t *testing.T) {
+	user := &managementv3.User{
+		ObjectMeta:   metav1.ObjectMeta{Name: "u-abc12"},
+		Username:     "jdoe",
+		PrincipalIDs: []string{"azuread_user://1234"},
+	}
+	groups := []managementv3.Principal{
+		{ObjectMeta: metav1.ObjectMeta{Name: "azuread_group://5678"}, DisplayName: "platform"},
+	}
+	cluster := &managementv3.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "c-m-xyz", Labels: map[string]string{"env": "dev"}},
+		Spec:       managementv3.ClusterSpec{DisplayName: "dev-eu"},
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		want       bool
+		wantErr    string
+	}{
+		{
+			name:       "groups and cluster labels",
+			expression: `'platform' in groupNames && cluster.labels['env'] != 'prod'`,
+			want:       true,
+		},
+		{
+			name:       "group principal IDs",
+			expression: `groups.exists(g, g.startsWith('azuread_group://'))`,
+			want:       true,
+		},
+		{
+			name:       "user attributes",
+			expression: `user.username == 'jdoe' && user.principalIds.size() == 1 && cluster.displayName.startsWith('prod')`,
+			want:       false,
+		},
+		{
+			name:       "missing label does not match",
+			expression: `cluster.labels['tier'] == 'gold'`,
+			wantErr:    "no such key",
+		},
+		{
+			name:       "unknown variable",
+			expression: `user.email == 'jdoe@example.com'`,
+			wantErr:    "undeclared reference",
+		},
+		{
+			name:       "not a bool",
+			expression: `cluster.name`,
+			wantErr:    "must evaluate to a bool",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := compileRuleExpression(tt.expression)
+			var got bool
+			if err == nil {
+				got, err = evaluateRuleExpression(program, user, groups, cluster)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v does not contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+// setStatusConditions records the outcome of a reconcile as the Ready, Degraded and InvalidSpec conditions.
+// invalidSpec takes precedence over failures, which are the per-binding or per-cluster errors met on the way.
+func setStatusConditions(conditions *[]metav1.Condition, generation int64, invalidSpec error, failures []string) {
+	ready := metav1.Condition{Type: permissionsv1alpha1.ConditionReady, Status: metav1.ConditionTrue, Reason: "BindingsApplied", Message: "All bindings are in place"}
+	degraded := metav1.Condition{Type: permissionsv1alpha1.ConditionDegraded, Status: metav1.ConditionFalse, Reason: "BindingsApplied"}
+	invalid := metav1.Condition{Type: permissionsv1alpha1.ConditionInvalidSpec, Status: metav1.ConditionFalse, Reason: "SpecValid"}
+
+	switch {
+	case invalidSpec != nil:
+		invalid.Status, invalid.Reason, invalid.Message = metav1.ConditionTrue, "InvalidSpec", invalidSpec.Error()
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, "InvalidSpec", invalidSpec.Error()
+	case len(failures) > 0:
+		message := strings.Join(failures, "; ")
+		degraded.Status, degraded.Reason, degraded.Message = metav1.ConditionTrue, "BindingsFailed", message
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, "BindingsFailed", message
+	}
+
+	for _, condition := range []metav1.Condition{ready, degraded, invalid} {
+		condition.ObservedGeneration = generation
+		meta.SetStatusCondition(conditions, condition)
+	}
+}
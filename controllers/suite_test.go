@@ -17,14 +17,22 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -37,9 +45,16 @@ import (
 // These tests use Ginkgo (BDD-style Go testing framework). Refer to
 // http://onsi.github.io/ginkgo/ to learn more about Ginkgo.
 
+// envtestTimeout and envtestInterval bound the wait for the reconcilers to act on a change.
+const (
+	envtestTimeout  = 10 * time.Second
+	envtestInterval = 100 * time.Millisecond
+)
+
 var cfg *rest.Config
 var k8sClient client.Client
 var testEnv *envtest.Environment
+var stopManager context.CancelFunc
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -48,11 +63,15 @@ func TestAPIs(t *testing.T) {
 }
 
 var _ = BeforeSuite(func() {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		Skip("KUBEBUILDER_ASSETS is not set, run make test to set up the test environment")
+	}
 	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
 
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
-		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases")},
+		// The Rancher resources are defined by minimal CRDs, Rancher itself does not run.
+		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases"), filepath.Join("testdata", "crds")},
 		ErrorIfCRDPathMissing: true,
 	}
 
@@ -64,6 +83,8 @@ var _ = BeforeSuite(func() {
 
 	err = permissionsv1alpha1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
+	err = managementv3.AddToScheme(scheme.Scheme)
+	Expect(err).NotTo(HaveOccurred())
 
 	//+kubebuilder:scaffold:scheme
 
@@ -71,10 +92,72 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
 
+	By("starting the reconcilers")
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{Scheme: scheme.Scheme, MetricsBindAddress: "0"})
+	Expect(err).NotTo(HaveOccurred())
+	var ctx context.Context
+	ctx, stopManager = context.WithCancel(context.Background())
+	Expect(IndexFields(ctx, mgr.GetFieldIndexer())).To(Succeed())
+	Expect((&ClusterAssignmentReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("rancher-operator-permissions"),
+	}).SetupWithManager(mgr)).To(Succeed())
+	Expect((&RoleMappingReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("rancher-operator-permissions"),
+	}).SetupWithManager(mgr)).To(Succeed())
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
 })
 
 var _ = AfterSuite(func() {
+	if testEnv == nil {
+		return
+	}
 	By("tearing down the test environment")
+	if stopManager != nil {
+		stopManager()
+	}
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
+
+// createCluster creates a Rancher cluster along with the namespace Rancher keeps its bindings in.
+func createCluster(ctx context.Context, name string) {
+	Expect(k8sClient.Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})).To(Succeed())
+	Expect(k8sClient.Create(ctx, &managementv3.Cluster{ObjectMeta: metav1.ObjectMeta{Name: name}})).To(Succeed())
+}
+
+// createRoleTemplate creates a role template of the given context.
+func createRoleTemplate(ctx context.Context, name, roleContext string, locked bool) {
+	Expect(k8sClient.Create(ctx, &managementv3.RoleTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Context:    roleContext,
+		Locked:     locked,
+	})).To(Succeed())
+}
+
+// clusterBindingsFor returns the ClusterRoleTemplateBindings in the cluster namespace carrying the annotation.
+func clusterBindingsFor(ctx context.Context, namespace, annotation, value string) []managementv3.ClusterRoleTemplateBinding {
+	var bindingList managementv3.ClusterRoleTemplateBindingList
+	Expect(k8sClient.List(ctx, &bindingList, client.InNamespace(namespace))).To(Succeed())
+	var bindings []managementv3.ClusterRoleTemplateBinding
+	for _, binding := range bindingList.Items {
+		if binding.Annotations[annotation] == value {
+			bindings = append(bindings, binding)
+		}
+	}
+	return bindings
+}
+
+// conditionOf returns the condition of the given type, or an empty one.
+func conditionOf(conditions []metav1.Condition, conditionType string) metav1.Condition {
+	if condition := meta.FindStatusCondition(conditions, conditionType); condition != nil {
+		return *condition
+	}
+	return metav1.Condition{}
+}
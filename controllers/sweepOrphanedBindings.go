@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// OrphanedBindingsSweep deletes, once at startup, the bindings the operator created for users that no longer
+// exist. Such bindings are left behind by users deleted while the operator was down or before the users carried
+// the finalizer. Bindings created under the created-by-pod annotation are relabelled first, so that the sweep
+// finds them.
+type OrphanedBindingsSweep struct {
+	client.Client
+	// Reader lists the users and bindings directly from the API server, the cache may not hold them yet.
+	Reader client.Reader
+}
+
+// Start runs the sweep. It implements manager.Runnable, and runs on the leader only.
+func (s *OrphanedBindingsSweep) Start(ctx context.Context) error {
+	if err := migrateLegacyBindings(ctx, s.Client, s.Reader); err != nil {
+		// The sweep would miss the bindings that are not relabelled.
+		globalLog.Error(err, "Failed to relabel bindings created under the created-by-pod annotation")
+		return nil
+	}
+	if err := s.sweep(ctx); err != nil {
+		// A failed sweep is retried on the next start, it must not stop the operator.
+		globalLog.Error(err, "Failed to sweep orphaned bindings")
+	}
+	return nil
+}
+
+// sweep lists the bindings before the users. A binding is only created for a user that exists, so the user of
+// every listed binding is listed too, unless it was deleted; listing the other way round would take the binding
+// of a user created in between for an orphan.
+func (s *OrphanedBindingsSweep) sweep(ctx context.Context) error {
+	var clusterBindingList managementv3.ClusterRoleTemplateBindingList
+	if err := s.Reader.List(ctx, &clusterBindingList, managedBindingLabels); err != nil {
+		return err
+	}
+	var projectBindingList managementv3.ProjectRoleTemplateBindingList
+	if err := s.Reader.List(ctx, &projectBindingList, managedBindingLabels); err != nil {
+		return err
+	}
+	var globalBindingList managementv3.GlobalRoleBindingList
+	if err := s.Reader.List(ctx, &globalBindingList, managedBindingLabels); err != nil {
+		return err
+	}
+
+	var userList managementv3.UserList
+	if err := s.Reader.List(ctx, &userList); err != nil {
+		return err
+	}
+	users := make(map[string]bool, len(userList.Items))
+	for _, user := range userList.Items {
+		users[user.Name] = true
+	}
+	orphaned := func(obj client.Object, userName string) bool {
+		return userName != "" && !users[userName] && isManaged(obj)
+	}
+
+	for i := range clusterBindingList.Items {
+		if binding := &clusterBindingList.Items[i]; orphaned(binding, binding.UserName) {
+			if err := s.deleteOrphan(ctx, "ClusterRoleTemplateBinding", binding, binding.UserName); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range projectBindingList.Items {
+		if binding := &projectBindingList.Items[i]; orphaned(binding, binding.UserName) {
+			if err := s.deleteOrphan(ctx, "ProjectRoleTemplateBinding", binding, binding.UserName); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range globalBindingList.Items {
+		if binding := &globalBindingList.Items[i]; orphaned(binding, binding.UserName) {
+			if err := s.deleteOrphan(ctx, "GlobalRoleBinding", binding, binding.UserName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *OrphanedBindingsSweep) deleteOrphan(ctx context.Context, kind string, binding client.Object, userName string) error {
+	var err error
+	if clusterBinding, ok := binding.(*managementv3.ClusterRoleTemplateBinding); ok {
+		// Cluster bindings are watched for drift, which must not include this deletion.
+		err = deleteClusterRoleTemplateBinding(ctx, s.Client, clusterBinding)
+	} else if err = s.Delete(ctx, binding); apierrors.IsNotFound(err) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	globalLog.Info("Deleted binding of a user that no longer exists", "kind", kind,
+		"Name", binding.GetName(), "Namespace", binding.GetNamespace(), "user", userName)
+	return nil
+}
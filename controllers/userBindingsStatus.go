@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// userBindingsCondition is the condition of a User that reports whether the operator could put all of its
+// bindings in place. Rancher keeps the other conditions of the user.
+const userBindingsCondition = "PermissionsBound"
+
+// clusterFailures collects the errors met on each cluster while reconciling a user, keyed by cluster name, so
+// that a failing cluster does not keep the bindings on the others from being applied.
+type clusterFailures map[string][]string
+
+func (f clusterFailures) add(clusterName string, format string, args ...interface{}) {
+	f[clusterName] = append(f[clusterName], fmt.Sprintf(format, args...))
+}
+
+// messages returns one message per failed cluster, ordered by cluster name.
+func (f clusterFailures) messages() []string {
+	clusterNames := make([]string, 0, len(f))
+	for clusterName := range f {
+		clusterNames = append(clusterNames, clusterName)
+	}
+	sort.Strings(clusterNames)
+
+	messages := make([]string, 0, len(clusterNames))
+	for _, clusterName := range clusterNames {
+		messages = append(messages, fmt.Sprintf("cluster %s: %s", clusterName, strings.Join(f[clusterName], ", ")))
+	}
+	return messages
+}
+
+// setUserBindingsCondition records the failures as the PermissionsBound condition of the user. It reports
+// whether the condition changed. The condition is only added on the first failure, so that the status of users
+// that never had one is left untouched.
+func setUserBindingsCondition(user *managementv3.User, failures clusterFailures, now time.Time) bool {
+	condition := managementv3.UserCondition{Type: userBindingsCondition, Status: corev1.ConditionTrue, Reason: "BindingsApplied", Message: "All bindings are in place"}
+	if len(failures) > 0 {
+		condition.Status, condition.Reason, condition.Message = corev1.ConditionFalse, "BindingsFailed", strings.Join(failures.messages(), "; ")
+	}
+	timestamp := now.UTC().Format(time.RFC3339)
+
+	for i := range user.Status.Conditions {
+		existing := &user.Status.Conditions[i]
+		if existing.Type != userBindingsCondition {
+			continue
+		}
+		if existing.Status == condition.Status && existing.Reason == condition.Reason && existing.Message == condition.Message {
+			return false
+		}
+		condition.LastTransitionTime = existing.LastTransitionTime
+		if existing.Status != condition.Status {
+			condition.LastTransitionTime = timestamp
+		}
+		condition.LastUpdateTime = timestamp
+		*existing = condition
+		return true
+	}
+	if len(failures) == 0 {
+		return false
+	}
+	condition.LastTransitionTime, condition.LastUpdateTime = timestamp, timestamp
+	user.Status.Conditions = append(user.Status.Conditions, condition)
+	return true
+}
+
+// reportClusterFailures records the outcome of applying the bindings of a user: the PermissionsBound
+// condition, and a warning event for each cluster that failed.
+func (r *UserReconciler) reportClusterFailures(ctx context.Context, user *managementv3.User, failures clusterFailures) error {
+	if r.Recorder != nil {
+		for _, message := range failures.messages() {
+			r.Recorder.Event(user, corev1.EventTypeWarning, "BindingFailed", message)
+		}
+	}
+	if !setUserBindingsCondition(user, failures, time.Now()) {
+		return nil
+	}
+	err := r.Status().Update(ctx, user)
+	if apierrors.IsNotFound(err) {
+		// Without a status subresource, the status is written along with the rest of the user.
+		err = r.Update(ctx, user)
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestSetUserBindingsCondition(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	user := &managementv3.User{Status: managementv3.UserStatus{Conditions: []managementv3.UserCondition{{Type: "InitialRolesPopulated", Status: corev1.ConditionTrue}}}}
+
+	if setUserBindingsCondition(user, clusterFailures{}, start) || len(user.Status.Conditions) != 1 {
+		t.Fatalf("setUserBindingsCondition() added a condition without failures: %v", user.Status.Conditions)
+	}
+
+	failures := clusterFailures{}
+	failures.add("c-def34", "rule %s: %s", "owners", "namespace c-def34 is being terminated")
+	failures.add("c-abc12", "rule %s: %s", "admins", "timeout")
+	failures.add("c-abc12", "revoking %s: %s", "old", "timeout")
+	if !setUserBindingsCondition(user, failures, start) {
+		t.Fatalf("setUserBindingsCondition() = false on the first failure")
+	}
+	condition := user.Status.Conditions[1]
+	wantMessage := "cluster c-abc12: rule admins: timeout, revoking old: timeout; cluster c-def34: rule owners: namespace c-def34 is being terminated"
+	if condition.Type != userBindingsCondition || condition.Status != corev1.ConditionFalse || condition.Message != wantMessage || condition.LastTransitionTime != "2024-05-01T12:00:00Z" {
+		t.Errorf("condition = %+v, want False with message %q", condition, wantMessage)
+	}
+
+	if setUserBindingsCondition(user, failures, start.Add(time.Minute)) {
+		t.Errorf("setUserBindingsCondition() = true for unchanged failures")
+	}
+
+	if !setUserBindingsCondition(user, clusterFailures{}, start.Add(2*time.Minute)) {
+		t.Fatalf("setUserBindingsCondition() = false on recovery")
+	}
+	condition = user.Status.Conditions[1]
+	if condition.Status != corev1.ConditionTrue || condition.Reason != "BindingsApplied" || condition.LastTransitionTime != "2024-05-01T12:02:00Z" {
+		t.Errorf("condition = %+v, want True since 12:02", condition)
+	}
+	if user.Status.Conditions[0].Type != "InitialRolesPopulated" {
+		t.Errorf("other conditions were changed: %v", user.Status.Conditions)
+	}
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// getUserAttribute returns the UserAttribute Rancher keeps for the named user, or nil if it has none, e.g. a
+// local user that never logged in.
+func getUserAttribute(ctx context.Context, c client.Client, userName string) (*managementv3.UserAttribute, error) {
+	attribute := &managementv3.UserAttribute{}
+	if err := c.Get(ctx, client.ObjectKey{Name: userName}, attribute); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return attribute, nil
+}
+
+// allUserAttributes returns the UserAttributes of every user that has one, keyed by user name.
+func allUserAttributes(ctx context.Context, c client.Client) (map[string]*managementv3.UserAttribute, error) {
+	var attributeList managementv3.UserAttributeList
+	if err := c.List(ctx, &attributeList); err != nil {
+		return nil, err
+	}
+	attributes := make(map[string]*managementv3.UserAttribute, len(attributeList.Items))
+	for i := range attributeList.Items {
+		attributes[attributeList.Items[i].Name] = &attributeList.Items[i]
+	}
+	return attributes, nil
+}
+
+// allUserGroupPrincipals returns the group principals of every user that has a UserAttribute, keyed by user name.
+func allUserGroupPrincipals(ctx context.Context, c client.Client) (map[string][]managementv3.Principal, error) {
+	attributes, err := allUserAttributes(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string][]managementv3.Principal, len(attributes))
+	for name, attribute := range attributes {
+		groups[name] = groupPrincipals(attribute)
+	}
+	return groups, nil
+}
+
+// groupPrincipals flattens the group principals of a UserAttribute, which are keyed by auth provider, ordered by
+// provider. The provider is recorded on principals that do not carry it already. A user without a UserAttribute
+// has none.
+func groupPrincipals(attribute *managementv3.UserAttribute) []managementv3.Principal {
+	if attribute == nil {
+		return nil
+	}
+	providers := make([]string, 0, len(attribute.GroupPrincipals))
+	for provider := range attribute.GroupPrincipals {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+
+	var groups []managementv3.Principal
+	for _, provider := range providers {
+		for _, group := range attribute.GroupPrincipals[provider].Items {
+			if group.Provider == "" {
+				group.Provider = provider
+			}
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// groupsRefreshed filters UserAttribute events down to those that can change the groups of a user: creation,
+// deletion, and updates that refresh the group principals.
+var groupsRefreshed = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldAttribute, ok := e.ObjectOld.(*managementv3.UserAttribute)
+		if !ok {
+			return false
+		}
+		newAttribute, ok := e.ObjectNew.(*managementv3.UserAttribute)
+		if !ok {
+			return false
+		}
+		return oldAttribute.LastRefresh != newAttribute.LastRefresh ||
+			!equality.Semantic.DeepEqual(oldAttribute.GroupPrincipals, newAttribute.GroupPrincipals)
+	},
+	GenericFunc: func(event.GenericEvent) bool { return false },
+}
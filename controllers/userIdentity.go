@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// loginNameExtraKey is the key under which Rancher records the login name of the principal a user logged in
+	// with, in the extra information of its UserAttribute for that provider.
+	loginNameExtraKey = "username"
+
+	// userAttributeLoginNameField indexes UserAttributes by the login names they record, in the form of
+	// derivedLoginName.
+	userAttributeLoginNameField = "loginName"
+)
+
+// userIdentity returns the user as rules see it. Users created through an auth provider login, e.g. Azure AD,
+// OIDC or LDAP, usually have no Username; they get the login name of their principal in its place, so that
+// username criteria, CEL expressions and cluster owners can name them. The user itself is never modified, a user
+// that has a Username or no known login name is returned as is.
+func userIdentity(user *managementv3.User, attribute *managementv3.UserAttribute) *managementv3.User {
+	if user.Username != "" {
+		return user
+	}
+	name := derivedLoginName(user, attribute)
+	if name == "" {
+		return user
+	}
+	identity := user.DeepCopy()
+	identity.Username = name
+	return identity
+}
+
+// loginName returns the Username of the user, or the login name derived from its principal if it has none.
+func loginName(user *managementv3.User, attribute *managementv3.UserAttribute) string {
+	return userIdentity(user, attribute).Username
+}
+
+// derivedLoginName returns the provider of the first external principal of the user that Rancher knows a login
+// name for, followed by a colon and that login name, e.g. azuread:jsmith@example.com. The provider keeps login
+// names of different providers apart, and the principal order keeps the name stable. It is empty if Rancher
+// recorded no login name for any of the principals.
+func derivedLoginName(user *managementv3.User, attribute *managementv3.UserAttribute) string {
+	if attribute == nil {
+		return ""
+	}
+	for _, principalID := range user.PrincipalIDs {
+		provider := principalProvider(principalID)
+		if provider == "" || provider == "local" {
+			continue
+		}
+		if logins := attribute.ExtraByProvider[provider][loginNameExtraKey]; len(logins) > 0 && logins[0] != "" {
+			return provider + ":" + logins[0]
+		}
+	}
+	return ""
+}
+
+// indexUserAttributeLoginName indexes a UserAttribute by every login name it records, whichever principal of the
+// user it belongs to, so that lookups may find more users than the login name is derived for but never fewer.
+func indexUserAttributeLoginName(obj client.Object) []string {
+	attribute, ok := obj.(*managementv3.UserAttribute)
+	if !ok {
+		return nil
+	}
+	var names []string
+	for provider, extra := range attribute.ExtraByProvider {
+		if logins := extra[loginNameExtraKey]; len(logins) > 0 && logins[0] != "" {
+			names = append(names, provider+":"+logins[0])
+		}
+	}
+	return names
+}
+
+// identityRefreshed filters UserAttribute events down to those that can change how rules see a user: creation,
+// deletion, and updates that refresh the group principals or the login names.
+var identityRefreshed = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		if groupsRefreshed.Update(e) {
+			return true
+		}
+		oldAttribute, ok := e.ObjectOld.(*managementv3.UserAttribute)
+		if !ok {
+			return false
+		}
+		newAttribute, ok := e.ObjectNew.(*managementv3.UserAttribute)
+		if !ok {
+			return false
+		}
+		return !equality.Semantic.DeepEqual(oldAttribute.ExtraByProvider, newAttribute.ExtraByProvider)
+	},
+	GenericFunc: func(event.GenericEvent) bool { return false },
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUserIdentity(t *testing.T) {
+	attribute := &managementv3.UserAttribute{
+		ObjectMeta: metav1.ObjectMeta{Name: "u-abc12"},
+		ExtraByProvider: map[string]map[string][]string{
+			"azuread":   {"principalid": {"azuread_user://5678"}, "username": {"jsmith@example.com"}},
+			"openldap":  {"principalid": {"openldap_user://uid=jsmith"}, "username": {"jsmith"}},
+			"githubapp": {"principalid": {"githubapp_user://1"}},
+		},
+	}
+	tests := []struct {
+		name      string
+		user      *managementv3.User
+		attribute *managementv3.UserAttribute
+		want      string
+	}{
+		{name: "username", user: &managementv3.User{Username: "admin", PrincipalIDs: []string{"local://u-abc12", "azuread_user://5678"}}, attribute: attribute, want: "admin"},
+		{name: "sso user", user: &managementv3.User{PrincipalIDs: []string{"local://u-abc12", "azuread_user://5678"}}, attribute: attribute, want: "azuread:jsmith@example.com"},
+		{name: "first principal wins", user: &managementv3.User{PrincipalIDs: []string{"openldap_user://uid=jsmith", "azuread_user://5678"}}, attribute: attribute, want: "openldap:jsmith"},
+		{name: "no login name", user: &managementv3.User{PrincipalIDs: []string{"githubapp_user://1"}}, attribute: attribute, want: ""},
+		{name: "no attribute", user: &managementv3.User{PrincipalIDs: []string{"azuread_user://5678"}}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			username := tt.user.Username
+			identity := userIdentity(tt.user, tt.attribute)
+			if identity.Username != tt.want {
+				t.Errorf("userIdentity().Username = %q, want %q", identity.Username, tt.want)
+			}
+			if tt.user.Username != username {
+				t.Errorf("userIdentity() modified the user, Username = %q", tt.user.Username)
+			}
+		})
+	}
+
+	t.Run("index", func(t *testing.T) {
+		names := map[string]bool{}
+		for _, name := range indexUserAttributeLoginName(attribute) {
+			names[name] = true
+		}
+		if len(names) != 2 || !names["azuread:jsmith@example.com"] || !names["openldap:jsmith"] {
+			t.Errorf("indexUserAttributeLoginName() = %v, want azuread:jsmith@example.com and openldap:jsmith", names)
+		}
+	})
+}
@@ -0,0 +1,339 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	provisioningv1 "github.com/rancher/rancher/pkg/apis/provisioning.cattle.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"strings"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+var globalLog = logf.Log
+
+// userBindingsFinalizer keeps a User around until the bindings the operator created for it are deleted.
+const userBindingsFinalizer = "permissions.xddevelopment.com/user-bindings"
+
+// UserReconciler reconciles a User object from management.cattle.io
+type UserReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Recorder records the replacement of bindings whose immutable fields changed.
+	Recorder record.EventRecorder
+	// RoleTemplatesFile holds the rules used while no RoleMapping exists.
+	RoleTemplatesFile *RoleTemplatesFile
+	// PrincipalProviders lists the auth providers, most preferred first, whose principal ID goes into the
+	// bindings of users with several. Without a preferred one, the first principal ID is used.
+	PrincipalProviders []string
+
+	pending pendingPrincipals
+}
+
+//+kubebuilder:rbac:groups=management.cattle.io,resources=users,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=management.cattle.io,resources=users/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=management.cattle.io,resources=clusters,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=management.cattle.io,resources=clusterroletemplatebindings,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=management.cattle.io,resources=userattributes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=provisioning.cattle.io,resources=clusters,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machines,verbs=*
+// +kubebuilder:rbac:groups=management.cattle.io,resources=clusters,verbs=*
+// +kubebuilder:rbac:groups=management.cattle.io,resources=projects,verbs=update
+// +kubebuilder:rbac:groups=provisioning.cattle.io,resources=clusters,verbs=*
+// +kubebuilder:rbac:groups=rke-machine-config.cattle.io,resources=*,verbs=*
+// +kubebuilder:rbac:groups=rke-machine.cattle.io,resources=*,verbs=*
+// +kubebuilder:rbac:groups=rke.cattle.io,resources=etcdsnapshots,verbs=get;list;watch
+// +kubebuilder:rbac:groups=*,resources=*,verbs=*
+// +kubebuilder:rbac:urls=*,verbs=*
+
+func (r *UserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	// Fetch the User instance
+	user := &managementv3.User{}
+	err := r.Get(ctx, req.NamespacedName, user)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// The user has been deleted. Nothing left to do.
+			r.pending.done(req.Name)
+			return ctrl.Result{}, nil
+		}
+		// handle error
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Check if the user is being deleted
+	if user.DeletionTimestamp != nil {
+		r.pending.done(user.Name)
+		// The user is being deleted, the finalizer keeps it around until its bindings are gone.
+		if result, err := r.deleteUserBindings(ctx, user.Name); err != nil {
+			return result, err
+		}
+		if controllerutil.RemoveFinalizer(user, userBindingsFinalizer) {
+			if err := r.Update(ctx, user); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// A disabled user is bound to nothing, until it is enabled again.
+	if isDisabled(user) {
+		r.pending.done(user.Name)
+		if err := r.revokeDisabledUser(ctx, user); err != nil {
+			globalLog.Error(err, "Failed to revoke bindings of disabled user", "user", user.Name)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	rules, held, err := loadRoleMappingRules(ctx, r.Client, r.RoleTemplatesFile)
+	if err != nil {
+		globalLog.Error(err, "Failed to load role mapping rules")
+		return ctrl.Result{}, err
+	}
+
+	var clusterList managementv3.ClusterList
+	if err := r.List(ctx, &clusterList); err != nil {
+		globalLog.Error(err, "Failed to retrieve list of clusters for user", "user", user.Name)
+		return ctrl.Result{}, err
+	}
+	if len(user.PrincipalIDs) == 0 {
+		// Rancher may attach a principal later without any other change to the user, so check again.
+		delay, first := r.pending.wait(user.Name)
+		globalLog.V(1).Info("User does not have yet any PrincipalIDs", "user", user.Name, "requeueAfter", delay)
+		if first && r.Recorder != nil {
+			r.Recorder.Event(user, corev1.EventTypeNormal, "PendingPrincipal", "User has no principal ID yet, its bindings are created once Rancher attaches one")
+		}
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+	r.pending.done(user.Name)
+	principalID := preferredPrincipalID(user.PrincipalIDs, r.PrincipalProviders)
+	attribute, err := getUserAttribute(ctx, r.Client, user.Name)
+	if err != nil {
+		globalLog.Error(err, "Failed to retrieve user attribute for user", "user", user.Name)
+		return ctrl.Result{}, err
+	}
+	groups := groupPrincipals(attribute)
+	// Rules match users that have no Username by the login name of their principal, bindings are named after
+	// the user object either way.
+	identity := userIdentity(user, attribute)
+	// Every binding the rules call for, so that the ones created earlier and no longer called for are revoked.
+	desired := map[client.ObjectKey]bool{}
+	// A failing cluster is reported and retried, without holding up the bindings on the other clusters.
+	failures := clusterFailures{}
+	check := newRoleTemplateCheck(r.Client, clusterRoleContext)
+	for _, rule := range rules {
+		if rule.bindsGroups() {
+			// Group rules are applied by the RoleMappingReconciler.
+			continue
+		}
+		if rule.matchesUser(identity, groups) {
+			// A rule whose role template Rancher would not bind creates nothing, but keeps what it created before.
+			problem, err := check.problem(ctx, rule.RoleTemplateName)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if problem != "" {
+				globalLog.Info("Not creating bindings of rule with invalid role template", "rule", rule.Name, "user", user.Name, "problem", problem)
+			}
+			// Check the user's attributes or groups to decide which clusters they should have access to.
+			clusters := determineClustersForUser(clusterList.Items, rule.clusters, identity, groups)
+			for i := range clusterList.Items {
+				cluster := &clusterList.Items[i]
+				clusterName := cluster.Name
+				reason, ok := clusters[clusterName]
+				if !ok {
+					continue
+				}
+				if !rule.matchesCluster(identity, groups, cluster) {
+					globalLog.V(1).Info("Rule expression does not match", "rule", rule.Name, "user", user.Name, "cluster", clusterName)
+					continue
+				}
+				globalLog.V(1).Info("Matching cluster found", "rule", rule.Name, "user", user.Name, "cluster", clusterName, "display name", cluster.Spec.DisplayName, "reason", reason)
+				// Define a ClusterRoleTemplateBinding for each cluster the user should have access to.
+				bindingName := user.Name + "-" + clusterName + "-" + rule.Name
+				binding := &managementv3.ClusterRoleTemplateBinding{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        bindingName,
+						Namespace:   clusterName,
+						Labels:      managedLabels(user.Name, rule.Name),
+						Annotations: map[string]string{},
+					},
+					RoleTemplateName:  rule.RoleTemplateName,
+					UserName:          user.Name,
+					UserPrincipalName: principalID,
+					ClusterName:       clusterName,
+				}
+				if rule.MappingName != "" {
+					binding.Annotations[roleMappingAnnotation] = rule.MappingName
+				}
+
+				// Keep failed bindings out of revocation, an existing binding must survive a failed update.
+				desired[client.ObjectKeyFromObject(binding)] = true
+				if problem != "" {
+					continue
+				}
+				// The finalizer goes on before the first binding, so that a user deleted in between does not leave
+				// it behind.
+				if controllerutil.AddFinalizer(user, userBindingsFinalizer) {
+					if err := r.Update(ctx, user); err != nil {
+						globalLog.Error(err, "Failed to add finalizer to user", "user", user.Name)
+						return ctrl.Result{}, err
+					}
+				}
+				if err := applyClusterRoleTemplateBinding(ctx, r.Client, r.Recorder, binding); err != nil {
+					globalLog.Error(err, "Failed to apply ClusterRoleTemplateBinding", "Name", binding.Name, "Namespace", binding.Namespace, "rule", rule.Name, "user", user.Name)
+					failures.add(clusterName, "rule %s: %s", rule.Name, err.Error())
+					continue
+				}
+				// The binding may have been replaced under another name.
+				desired[client.ObjectKeyFromObject(binding)] = true
+			}
+		} else {
+			globalLog.V(1).Info("Rule does not select user", "rule", rule.Name, "user.Username", identity.Username)
+		}
+	}
+
+	if err := r.revokeStaleBindings(ctx, user, desired, held, failures); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Only users with bindings need the finalizer, the others can be deleted without waiting for the operator.
+	// Bindings that failed to be revoked, or are kept for a rule that fails validation, keep it in place.
+	changed := false
+	if len(desired) == 0 && controllerutil.ContainsFinalizer(user, userBindingsFinalizer) {
+		bound, err := r.hasManagedBindings(ctx, user.Name)
+		if err != nil {
+			globalLog.Error(err, "Failed to list bindings of user", "user", user.Name)
+			return ctrl.Result{}, err
+		}
+		if !bound {
+			changed = controllerutil.RemoveFinalizer(user, userBindingsFinalizer)
+		}
+	}
+	restored := clearAccessRevoked(user)
+	if changed || restored {
+		if err := r.Update(ctx, user); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if restored {
+		r.recordAccessRestored(user)
+	}
+
+	if err := r.reportClusterFailures(ctx, user, failures); err != nil {
+		globalLog.Error(err, "Failed to update status of user", "user", user.Name)
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if len(failures) > 0 {
+		// The retry applies every binding again, those already in place are compared and left as they are.
+		return ctrl.Result{}, fmt.Errorf("user %s is degraded: %d cluster(s) failed", user.Name, len(failures))
+	}
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *UserReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	controllerBuilder := ctrl.NewControllerManagedBy(mgr).
+		For(&managementv3.User{}).
+		Watches(&source.Kind{Type: &permissionsv1alpha1.RoleMapping{}}, handler.EnqueueRequestsFromMapFunc(r.usersForRoleMapping)).
+		// A UserAttribute is named after its user. Rancher rewrites it on every login, only a refresh of the groups
+		// or login names matters.
+		Watches(&source.Kind{Type: &managementv3.UserAttribute{}}, &handler.EnqueueRequestForObject{}, builder.WithPredicates(identityRefreshed)).
+		// A new or relabelled cluster grants or revokes access without any change to the users.
+		Watches(&source.Kind{Type: &managementv3.Cluster{}}, handler.EnqueueRequestsFromMapFunc(r.usersForCluster), builder.WithPredicates(clusterSelectionChanged)).
+		Watches(&source.Kind{Type: &provisioningv1.Cluster{}}, handler.EnqueueRequestsFromMapFunc(r.usersForProvisioningCluster), builder.WithPredicates(provisioningClusterChanged)).
+		Watches(&source.Kind{Type: &managementv3.ClusterRoleTemplateBinding{}}, enqueueBindingOwner(userRuleBindingOwner), builder.WithPredicates(bindingDrift(userRuleBindingOwner, r.Recorder)))
+	if r.RoleTemplatesFile != nil {
+		controllerBuilder = controllerBuilder.Watches(&source.Channel{Source: r.RoleTemplatesFile.Changes()}, handler.EnqueueRequestsFromMapFunc(r.allUsers))
+	}
+	return controllerBuilder.Complete(r)
+}
+
+// allUsers requeues every user, after a change of the role templates file.
+func (r *UserReconciler) allUsers(_ client.Object) []reconcile.Request {
+	var userList managementv3.UserList
+	if err := r.List(context.Background(), &userList); err != nil {
+		globalLog.Error(err, "Failed to list users")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(userList.Items))
+	for _, user := range userList.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&user)})
+	}
+	return requests
+}
+
+// usersForRoleMapping requeues the users affected by a change to a RoleMapping: those its rules select now,
+// and those holding bindings that were created for it.
+func (r *UserReconciler) usersForRoleMapping(obj client.Object) []reconcile.Request {
+	mapping, ok := obj.(*permissionsv1alpha1.RoleMapping)
+	if !ok {
+		return nil
+	}
+	ctx := context.Background()
+
+	userNames := map[string]bool{}
+	var userList managementv3.UserList
+	if err := r.List(ctx, &userList); err != nil {
+		globalLog.Error(err, "Failed to list users for RoleMapping", "roleMapping", mapping.Name)
+		return nil
+	}
+	attributes, err := allUserAttributes(ctx, r.Client)
+	if err != nil {
+		globalLog.Error(err, "Failed to list user attributes for RoleMapping", "roleMapping", mapping.Name)
+		return nil
+	}
+	rules := make([]mappingRule, 0, len(mapping.Spec.Rules))
+	for _, compiled := range compiledRoleMappingRules(mapping) {
+		if !compiled.bindsGroups() {
+			rules = append(rules, compiled.mappingRule)
+		}
+	}
+	for i := range userList.Items {
+		attribute := attributes[userList.Items[i].Name]
+		identity := userIdentity(&userList.Items[i], attribute)
+		for _, rule := range rules {
+			if rule.matchesUser(identity, groupPrincipals(attribute)) {
+				userNames[userList.Items[i].Name] = true
+				break
+			}
+		}
+	}
+
+	var bindingList managementv3.ClusterRoleTemplateBindingList
+	if err := r.List(ctx, &bindingList, managedBindings); err != nil {
+		globalLog.Error(err, "Failed to list ClusterRoleTemplateBindings for RoleMapping", "roleMapping", mapping.Name)
+		return nil
+	}
+	for _, binding := range bindingList.Items {
+		if binding.Annotations[roleMappingAnnotation] == mapping.Name && binding.UserName != "" {
+			userNames[binding.UserName] = true
+		}
+	}
+
+	requests := make([]reconcile.Request, 0, len(userNames))
+	for name := range userNames {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKey{Name: name}})
+	}
+	return requests
+}
+
+// Helper function to check if a string contains a substring.
+func contains(s, substr string) bool {
+	return strings.Contains(s, substr)
+}
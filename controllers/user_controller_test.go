@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+// userTestObjects returns a user selected by the admins rule of a RoleMapping, which grants cluster-admin on
+// the named clusters, together with the role template and the clusters.
+func userTestObjects(clusterNames ...string) []client.Object {
+	objs := []client.Object{
+		&managementv3.User{ObjectMeta: metav1.ObjectMeta{Name: "u-abc12"}, Username: "jdoe-admin", PrincipalIDs: []string{"local://u-abc12"}},
+		&managementv3.RoleTemplate{ObjectMeta: metav1.ObjectMeta{Name: "cluster-admin"}, Context: clusterRoleContext},
+		&permissionsv1alpha1.RoleMapping{
+			ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec: permissionsv1alpha1.RoleMappingSpec{Rules: []permissionsv1alpha1.RoleMappingRule{{
+				Name:             "admins",
+				UserSelector:     permissionsv1alpha1.UserSelector{Substring: "admin"},
+				RoleTemplateName: "cluster-admin",
+				Clusters:         &permissionsv1alpha1.ClusterScope{Names: clusterNames},
+			}}},
+		},
+	}
+	for _, name := range clusterNames {
+		objs = append(objs, &managementv3.Cluster{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	return objs
+}
+
+func reconcileUser(t *testing.T, r *UserReconciler, name string) error {
+	t.Helper()
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKey{Name: name}})
+	return err
+}
+
+// finalizerOnCreate records whether the user carries the finalizer whenever a binding is created.
+type finalizerOnCreate struct {
+	client.Client
+	created, withFinalizer int
+}
+
+func (c *finalizerOnCreate) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if binding, ok := obj.(*managementv3.ClusterRoleTemplateBinding); ok {
+		c.created++
+		user := &managementv3.User{}
+		if err := c.Get(ctx, client.ObjectKey{Name: binding.UserName}, user); err == nil && controllerutil.ContainsFinalizer(user, userBindingsFinalizer) {
+			c.withFinalizer++
+		}
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+// failingNamespace fails every Create in one namespace, as a terminating cluster namespace would.
+type failingNamespace struct {
+	client.Client
+	namespace string
+}
+
+func (c failingNamespace) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if obj.GetNamespace() == c.namespace {
+		return errors.New("namespace is being terminated")
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+// clusterBindingNamespaces returns the namespaces holding a ClusterRoleTemplateBinding.
+func clusterBindingNamespaces(t *testing.T, c client.Client) map[string]bool {
+	t.Helper()
+	var bindingList managementv3.ClusterRoleTemplateBindingList
+	if err := c.List(context.Background(), &bindingList); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	namespaces := map[string]bool{}
+	for _, binding := range bindingList.Items {
+		namespaces[binding.Namespace] = true
+	}
+	return namespaces
+}
+
+// drainEvents returns the events recorded so far.
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestUserReconcilerAddsFinalizerBeforeFirstBinding(t *testing.T) {
+	c := &finalizerOnCreate{Client: newFakeClient(userTestObjects("c-abc12", "c-def34")...)}
+	r := &UserReconciler{Client: c, Recorder: record.NewFakeRecorder(10)}
+	if err := reconcileUser(t, r, "u-abc12"); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if c.created != 2 || c.withFinalizer != 2 {
+		t.Errorf("created %d bindings, %d of them with the finalizer on the user, want 2 and 2", c.created, c.withFinalizer)
+	}
+}
+
+func TestUserReconcilerDeletion(t *testing.T) {
+	now := metav1.Now()
+	user := &managementv3.User{
+		ObjectMeta: metav1.ObjectMeta{Name: "u-abc12", DeletionTimestamp: &now, Finalizers: []string{userBindingsFinalizer}},
+		Username:   "jdoe-admin",
+	}
+	binding := &managementv3.ClusterRoleTemplateBinding{
+		ObjectMeta:       metav1.ObjectMeta{Name: "u-abc12-c-abc12-admins", Namespace: "c-abc12", Labels: managedLabels("u-abc12", "admins")},
+		RoleTemplateName: "cluster-admin",
+		UserName:         "u-abc12",
+		ClusterName:      "c-abc12",
+	}
+	c := newFakeClient(user, binding)
+
+	r := &UserReconciler{Client: failingDeletes{c}}
+	if err := reconcileUser(t, r, user.Name); err == nil {
+		t.Fatal("Reconcile() error = nil, want the failed delete")
+	}
+	got := &managementv3.User{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(user), got); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !controllerutil.ContainsFinalizer(got, userBindingsFinalizer) {
+		t.Error("finalizer removed although the binding could not be deleted")
+	}
+
+	r = &UserReconciler{Client: c}
+	if err := reconcileUser(t, r, user.Name); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(binding), &managementv3.ClusterRoleTemplateBinding{}); !apierrors.IsNotFound(err) {
+		t.Errorf("binding still exists, Get() error = %v", err)
+	}
+	err := c.Get(context.Background(), client.ObjectKeyFromObject(user), got)
+	if err == nil && controllerutil.ContainsFinalizer(got, userBindingsFinalizer) {
+		t.Error("finalizer kept after the bindings were deleted")
+	} else if err != nil && !apierrors.IsNotFound(err) {
+		t.Fatalf("Get() error = %v", err)
+	}
+}
+
+func TestOrphanedBindingsSweep(t *testing.T) {
+	binding := func(name, userName string) *managementv3.ClusterRoleTemplateBinding {
+		return &managementv3.ClusterRoleTemplateBinding{
+			ObjectMeta:       metav1.ObjectMeta{Name: name, Namespace: "c-abc12", Labels: managedLabels(userName, "admins")},
+			RoleTemplateName: "cluster-admin",
+			UserName:         userName,
+			ClusterName:      "c-abc12",
+		}
+	}
+	orphan := binding("orphan", "u-gone1")
+	kept := binding("kept", "u-abc12")
+	globalOrphan := &managementv3.GlobalRoleBinding{
+		ObjectMeta:     metav1.ObjectMeta{Name: "global-orphan", Labels: managedLabels("u-gone1", "")},
+		GlobalRoleName: "user-base",
+		UserName:       "u-gone1",
+	}
+	c := newFakeClient(&managementv3.User{ObjectMeta: metav1.ObjectMeta{Name: "u-abc12"}}, orphan, kept, globalOrphan)
+
+	sweep := &OrphanedBindingsSweep{Client: c, Reader: c}
+	if err := sweep.sweep(context.Background()); err != nil {
+		t.Fatalf("sweep() error = %v", err)
+	}
+	for _, tc := range []struct {
+		obj  client.Object
+		want bool
+	}{
+		{orphan, false},
+		{globalOrphan, false},
+		{kept, true},
+	} {
+		err := c.Get(context.Background(), client.ObjectKeyFromObject(tc.obj), tc.obj)
+		if exists := err == nil; exists != tc.want {
+			t.Errorf("binding %s exists = %v, want %v", tc.obj.GetName(), exists, tc.want)
+		}
+	}
+}
+
+func TestUserReconcilerFailingCluster(t *testing.T) {
+	c := newFakeClient(userTestObjects("c-abc12", "c-bad99", "c-def34")...)
+	recorder := record.NewFakeRecorder(10)
+	r := &UserReconciler{Client: failingNamespace{Client: c, namespace: "c-bad99"}, Recorder: recorder}
+	err := reconcileUser(t, r, "u-abc12")
+	if err == nil || !strings.Contains(err.Error(), "1 cluster(s) failed") {
+		t.Errorf("Reconcile() error = %v, want the failed cluster", err)
+	}
+
+	if got := clusterBindingNamespaces(t, c); !got["c-abc12"] || !got["c-def34"] || got["c-bad99"] {
+		t.Errorf("bindings in %v, want c-abc12 and c-def34 only", got)
+	}
+	events := drainEvents(recorder)
+	if len(events) != 1 || !strings.Contains(events[0], "BindingFailed cluster c-bad99: rule admins: namespace is being terminated") {
+		t.Errorf("events = %v, want one BindingFailed event for c-bad99", events)
+	}
+	user := &managementv3.User{}
+	if err := c.Get(context.Background(), client.ObjectKey{Name: "u-abc12"}, user); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(user.Status.Conditions) != 1 || user.Status.Conditions[0].Type != userBindingsCondition || user.Status.Conditions[0].Reason != "BindingsFailed" {
+		t.Errorf("conditions = %+v, want %s failed", user.Status.Conditions, userBindingsCondition)
+	}
+}
@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"context"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	provisioningv1 "github.com/rancher/rancher/pkg/apis/provisioning.cattle.io/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+// userUsernameField indexes Users by their Username, which is how cluster owner labels usually name them.
+const userUsernameField = "username"
+
+func indexUserUsername(obj client.Object) []string {
+	user, ok := obj.(*managementv3.User)
+	if !ok || user.Username == "" {
+		return nil
+	}
+	return []string{user.Username}
+}
+
+// clusterCandidates are the users a cluster may be selected for by one rule: every user the rule selects, or
+// only the users and the members of the groups the cluster names as its owners.
+type clusterCandidates struct {
+	everyone bool
+	// users holds user names and usernames.
+	users map[string]bool
+	// groups holds group principal names and display names.
+	groups map[string]bool
+}
+
+// candidatesForCluster works out the users the scope may select the cluster for, without looking at any user.
+func candidatesForCluster(cluster *managementv3.Cluster, scope *clusterScope) clusterCandidates {
+	if scope == nil {
+		scope = defaultClusterScope
+	}
+	candidates := clusterCandidates{users: map[string]bool{}, groups: map[string]bool{}}
+	if isListed(scope.Names, cluster.Name) {
+		candidates.everyone = true
+		return candidates
+	}
+	if !hasClusterCriteria(scope.ClusterScope) {
+		return candidates
+	}
+
+	// Every criterion but ownership holds or fails regardless of the user.
+	criteria := *scope.ClusterScope
+	criteria.Names = nil
+	criteria.Ownership = nil
+	unowned := *scope
+	unowned.ClusterScope = &criteria
+	if hasClusterCriteria(&criteria) {
+		if _, ok := clusterInScope(cluster, &unowned, &managementv3.User{}, nil); !ok {
+			return candidates
+		}
+	}
+	if len(scope.Ownership) == 0 {
+		candidates.everyone = true
+		return candidates
+	}
+
+	for _, ownership := range scope.Ownership {
+		switch ownership.Type {
+		case permissionsv1alpha1.OwnerOwnership:
+			for _, owner := range clusterOwners(cluster, ownerKey(ownership, defaultOwnerKey)) {
+				candidates.users[owner.value] = true
+			}
+		case permissionsv1alpha1.OwnerGroupOwnership:
+			for _, owner := range clusterOwners(cluster, ownerKey(ownership, defaultOwnerGroupKey)) {
+				candidates.groups[owner.value] = true
+			}
+		case permissionsv1alpha1.CreatorOwnership:
+			if creator := cluster.Annotations[creatorIDAnnotation]; creator != "" {
+				candidates.users[creator] = true
+			}
+		}
+	}
+	return candidates
+}
+
+// usersForCluster requeues the users affected by a change to a management cluster.
+func (r *UserReconciler) usersForCluster(obj client.Object) []reconcile.Request {
+	cluster, ok := obj.(*managementv3.Cluster)
+	if !ok {
+		return nil
+	}
+	return r.affectedUsers(context.Background(), cluster)
+}
+
+// usersForProvisioningCluster requeues the users affected by a change to a provisioning cluster, through the
+// management cluster Rancher created for it.
+func (r *UserReconciler) usersForProvisioningCluster(obj client.Object) []reconcile.Request {
+	provisioningCluster, ok := obj.(*provisioningv1.Cluster)
+	if !ok || provisioningCluster.Status.ClusterName == "" {
+		return nil
+	}
+	ctx := context.Background()
+
+	cluster := &managementv3.Cluster{}
+	if err := r.Get(ctx, client.ObjectKey{Name: provisioningCluster.Status.ClusterName}, cluster); err != nil {
+		if !apierrors.IsNotFound(err) {
+			globalLog.Error(err, "Failed to get cluster for provisioning cluster", "Name", provisioningCluster.Name, "Namespace", provisioningCluster.Namespace)
+		}
+		return nil
+	}
+	return r.affectedUsers(ctx, cluster)
+}
+
+// affectedUsers returns the users the rules may bind on the cluster, and those holding bindings on it that the
+// rules may no longer call for. The users named by the cluster are looked up by name and through the username
+// and login name indexes, only rules that select clusters regardless of ownership check every user.
+func (r *UserReconciler) affectedUsers(ctx context.Context, cluster *managementv3.Cluster) []reconcile.Request {
+	rules, _, err := loadRoleMappingRules(ctx, r.Client, r.RoleTemplatesFile)
+	if err != nil {
+		globalLog.Error(err, "Failed to load role mapping rules for cluster", "cluster", cluster.Name)
+		return nil
+	}
+
+	var users []managementv3.User
+	var attributes map[string]*managementv3.UserAttribute
+	loadUsers := func() error {
+		if users != nil {
+			return nil
+		}
+		var userList managementv3.UserList
+		if err := r.List(ctx, &userList); err != nil {
+			return err
+		}
+		users = userList.Items
+		attributes, err = allUserAttributes(ctx, r.Client)
+		return err
+	}
+
+	userNames := map[string]bool{}
+	for _, rule := range rules {
+		if rule.bindsGroups() {
+			continue
+		}
+		candidates := candidatesForCluster(cluster, rule.clusters)
+		if candidates.everyone || len(candidates.groups) > 0 {
+			if err := loadUsers(); err != nil {
+				globalLog.Error(err, "Failed to list users for cluster", "cluster", cluster.Name)
+				return nil
+			}
+			for i := range users {
+				attribute := attributes[users[i].Name]
+				groups := groupPrincipals(attribute)
+				if candidates.everyone && rule.matchesUser(userIdentity(&users[i], attribute), groups) {
+					userNames[users[i].Name] = true
+				}
+				for _, group := range groups {
+					if candidates.groups[group.Name] || candidates.groups[group.DisplayName] {
+						userNames[users[i].Name] = true
+					}
+				}
+			}
+		}
+		for owner := range candidates.users {
+			names, err := r.usersNamed(ctx, owner)
+			if err != nil {
+				globalLog.Error(err, "Failed to look up cluster owner", "cluster", cluster.Name, "owner", owner)
+				return nil
+			}
+			for _, name := range names {
+				userNames[name] = true
+			}
+		}
+	}
+
+	var bindingList managementv3.ClusterRoleTemplateBindingList
+	if err := r.List(ctx, &bindingList, client.InNamespace(cluster.Name), managedBindings); err != nil {
+		globalLog.Error(err, "Failed to list ClusterRoleTemplateBindings for cluster", "cluster", cluster.Name)
+		return nil
+	}
+	for i := range bindingList.Items {
+		if binding := &bindingList.Items[i]; isUserRuleBinding(binding, binding.UserName) && binding.UserName != "" {
+			userNames[binding.UserName] = true
+		}
+	}
+
+	requests := make([]reconcile.Request, 0, len(userNames))
+	for name := range userNames {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKey{Name: name}})
+	}
+	return requests
+}
+
+// usersNamed returns the names of the users whose name, username or login name is owner. Login names are looked
+// up through the UserAttributes, which are named after their users.
+func (r *UserReconciler) usersNamed(ctx context.Context, owner string) ([]string, error) {
+	var names []string
+	user := &managementv3.User{}
+	if err := r.Get(ctx, client.ObjectKey{Name: owner}, user); err == nil {
+		names = append(names, user.Name)
+	} else if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	var userList managementv3.UserList
+	if err := r.List(ctx, &userList, client.MatchingFields{userUsernameField: owner}); err != nil {
+		return nil, err
+	}
+	for i := range userList.Items {
+		names = append(names, userList.Items[i].Name)
+	}
+
+	var attributeList managementv3.UserAttributeList
+	if err := r.List(ctx, &attributeList, client.MatchingFields{userAttributeLoginNameField: owner}); err != nil {
+		return nil, err
+	}
+	for i := range attributeList.Items {
+		names = append(names, attributeList.Items[i].Name)
+	}
+	return names, nil
+}
+
+// clusterSelectionChanged filters cluster events down to those that can change which rules select the cluster:
+// creation, deletion, and updates of the labels, annotations, display name, Fleet workspace, provider or driver.
+// Rancher updates the status of clusters all the time, the rest of it does not matter here.
+var clusterSelectionChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldCluster, ok := e.ObjectOld.(*managementv3.Cluster)
+		if !ok {
+			return false
+		}
+		newCluster, ok := e.ObjectNew.(*managementv3.Cluster)
+		if !ok {
+			return false
+		}
+		return !equality.Semantic.DeepEqual(oldCluster.Labels, newCluster.Labels) ||
+			!equality.Semantic.DeepEqual(oldCluster.Annotations, newCluster.Annotations) ||
+			oldCluster.Spec.DisplayName != newCluster.Spec.DisplayName ||
+			oldCluster.Spec.FleetWorkspaceName != newCluster.Spec.FleetWorkspaceName ||
+			oldCluster.Status.Provider != newCluster.Status.Provider ||
+			oldCluster.Status.Driver != newCluster.Status.Driver
+	},
+	GenericFunc: func(event.GenericEvent) bool { return false },
+}
+
+// provisioningClusterChanged filters provisioning cluster events down to those that can change the management
+// cluster: creation, deletion, and updates of the labels, annotations or the management cluster name.
+var provisioningClusterChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldCluster, ok := e.ObjectOld.(*provisioningv1.Cluster)
+		if !ok {
+			return false
+		}
+		newCluster, ok := e.ObjectNew.(*provisioningv1.Cluster)
+		if !ok {
+			return false
+		}
+		return !equality.Semantic.DeepEqual(oldCluster.Labels, newCluster.Labels) ||
+			!equality.Semantic.DeepEqual(oldCluster.Annotations, newCluster.Annotations) ||
+			oldCluster.Status.ClusterName != newCluster.Status.ClusterName
+	},
+	GenericFunc: func(event.GenericEvent) bool { return false },
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	permissionsv1alpha1 "github.com/lukasz-bielinski/rancher-operator-permissions/api/v1alpha1"
+)
+
+func TestCandidatesForCluster(t *testing.T) {
+	cluster := &managementv3.Cluster{ObjectMeta: metav1.ObjectMeta{
+		Name:   "c-prod",
+		Labels: map[string]string{"env": "prod", "owner": "jsmith", "team": "platform"},
+		Annotations: map[string]string{
+			"owner.1":                   "alice, bob",
+			"owner-group":               "platform",
+			"field.cattle.io/creatorId": "u-abc12",
+		},
+	}}
+	set := func(values ...string) map[string]bool {
+		m := map[string]bool{}
+		for _, value := range values {
+			m[value] = true
+		}
+		return m
+	}
+
+	tests := []struct {
+		name  string
+		scope *permissionsv1alpha1.ClusterScope
+		want  clusterCandidates
+	}{
+		{
+			name:  "default scope names the owners",
+			scope: nil,
+			want:  clusterCandidates{users: set("jsmith", "alice", "bob"), groups: set()},
+		},
+		{
+			name: "owner groups and creator",
+			scope: &permissionsv1alpha1.ClusterScope{Ownership: []permissionsv1alpha1.ClusterOwnership{
+				{Type: permissionsv1alpha1.OwnerGroupOwnership},
+				{Type: permissionsv1alpha1.CreatorOwnership},
+			}},
+			want: clusterCandidates{users: set("u-abc12"), groups: set("platform")},
+		},
+		{
+			name:  "listed cluster concerns everyone",
+			scope: &permissionsv1alpha1.ClusterScope{Names: []string{"c-prod"}},
+			want:  clusterCandidates{everyone: true, users: set(), groups: set()},
+		},
+		{
+			name:  "unlisted cluster concerns nobody",
+			scope: &permissionsv1alpha1.ClusterScope{Names: []string{"c-dev"}},
+			want:  clusterCandidates{users: set(), groups: set()},
+		},
+		{
+			name:  "matching selector concerns everyone",
+			scope: &permissionsv1alpha1.ClusterScope{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}},
+			want:  clusterCandidates{everyone: true, users: set(), groups: set()},
+		},
+		{
+			name: "selector and ownership name the owners",
+			scope: &permissionsv1alpha1.ClusterScope{
+				Selector:  &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+				Ownership: []permissionsv1alpha1.ClusterOwnership{{Type: permissionsv1alpha1.OwnerOwnership, Key: "team"}},
+			},
+			want: clusterCandidates{users: set("platform"), groups: set()},
+		},
+		{
+			name: "mismatched selector concerns nobody",
+			scope: &permissionsv1alpha1.ClusterScope{
+				Selector:  &metav1.LabelSelector{MatchLabels: map[string]string{"env": "dev"}},
+				Ownership: []permissionsv1alpha1.ClusterOwnership{{Type: permissionsv1alpha1.OwnerOwnership}},
+			},
+			want: clusterCandidates{users: set(), groups: set()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, problems := compileClusterScope(tt.scope, permissionsv1alpha1.UserTarget)
+			if len(problems) > 0 {
+				t.Fatalf("compileClusterScope() problems = %v", problems)
+			}
+			if got := candidatesForCluster(cluster, scope); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("candidatesForCluster() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	// clusterRoleContext and projectRoleContext are the contexts of the role templates that ClusterRoleTemplateBindings
+	// and ProjectRoleTemplateBindings may refer to.
+	clusterRoleContext = "cluster"
+	projectRoleContext = "project"
+)
+
+// roleTemplateCheck validates the role templates that bindings of one context refer to, and remembers the
+// outcome, so that a reconcile reads every role template once.
+type roleTemplateCheck struct {
+	reader      client.Reader
+	roleContext string
+	problems    map[string]string
+}
+
+func newRoleTemplateCheck(reader client.Reader, roleContext string) *roleTemplateCheck {
+	return &roleTemplateCheck{reader: reader, roleContext: roleContext, problems: map[string]string{}}
+}
+
+// problem returns why bindings cannot refer to the named role template, or an empty string if they can.
+func (c *roleTemplateCheck) problem(ctx context.Context, name string) (string, error) {
+	if problem, ok := c.problems[name]; ok {
+		return problem, nil
+	}
+	problem, err := roleTemplateProblem(ctx, c.reader, name, c.roleContext)
+	if err != nil {
+		return "", err
+	}
+	c.problems[name] = problem
+	return problem, nil
+}
+
+// roleTemplateProblem returns why bindings of the given context cannot refer to the named role template: it does
+// not exist, it is locked, its context differs, or a role template it inherits from, directly or not, does not
+// exist or has another context. Rancher rejects such bindings, or grants nothing through them. The result is
+// empty for a valid role template; errors are those of reading the role templates.
+func roleTemplateProblem(ctx context.Context, reader client.Reader, name, roleContext string) (string, error) {
+	path := []string{name}
+	seen := map[string]bool{}
+	for queue := [][]string{path}; len(queue) > 0; queue = queue[1:] {
+		path = queue[0]
+		current := path[len(path)-1]
+		if seen[current] {
+			continue
+		}
+		seen[current] = true
+
+		roleTemplate := &managementv3.RoleTemplate{}
+		if err := reader.Get(ctx, client.ObjectKey{Name: current}, roleTemplate); err != nil {
+			if apierrors.IsNotFound(err) {
+				return describeRoleTemplate(path) + " not found", nil
+			}
+			return "", err
+		}
+		// Locking only keeps new bindings from referring to the role template itself.
+		if len(path) == 1 && roleTemplate.Locked {
+			return describeRoleTemplate(path) + " is locked", nil
+		}
+		if roleTemplate.Context != roleContext {
+			return fmt.Sprintf("%s has context %q, not %q", describeRoleTemplate(path), roleTemplate.Context, roleContext), nil
+		}
+		for _, inherited := range roleTemplate.RoleTemplateNames {
+			for _, ancestor := range path {
+				if ancestor == inherited {
+					return "role templates inherit from each other in a cycle: " + strings.Join(append(path, inherited), " > "), nil
+				}
+			}
+			queue = append(queue, append(append([]string(nil), path...), inherited))
+		}
+	}
+	return "", nil
+}
+
+// describeRoleTemplate names the last role template of an inheritance path, along with the path to it.
+func describeRoleTemplate(path []string) string {
+	if len(path) == 1 {
+		return fmt.Sprintf("role template %q", path[0])
+	}
+	return fmt.Sprintf("role template %q, inherited through %s,", path[len(path)-1], strings.Join(path, " > "))
+}
+
+// checkAssignmentRoleTemplates validates the role templates an assignment names. It returns the problem of each
+// of them in the order of names, empty for valid ones, and the failures to report for the invalid ones.
+func checkAssignmentRoleTemplates(ctx context.Context, reader client.Reader, names []string, roleContext string) (problems []string, failures []string, err error) {
+	check := newRoleTemplateCheck(reader, roleContext)
+	problems = make([]string, len(names))
+	for i, name := range names {
+		if problems[i], err = check.problem(ctx, name); err != nil {
+			return nil, nil, err
+		}
+		if problems[i] != "" {
+			failures = append(failures, fmt.Sprintf("roleTemplateNames[%d]: %s", i, problems[i]))
+		}
+	}
+	return problems, failures, nil
+}
+
+// roleTemplateValidityChanged filters role template events down to those that can change what roleTemplateProblem
+// reports: creation, deletion, and updates of the context, the lock or the inherited role templates.
+var roleTemplateValidityChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldRoleTemplate, ok := e.ObjectOld.(*managementv3.RoleTemplate)
+		if !ok {
+			return false
+		}
+		newRoleTemplate, ok := e.ObjectNew.(*managementv3.RoleTemplate)
+		if !ok {
+			return false
+		}
+		return oldRoleTemplate.Context != newRoleTemplate.Context ||
+			oldRoleTemplate.Locked != newRoleTemplate.Locked ||
+			!equality.Semantic.DeepEqual(oldRoleTemplate.RoleTemplateNames, newRoleTemplate.RoleTemplateNames)
+	},
+	GenericFunc: func(event.GenericEvent) bool { return false },
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// roleTemplateReader serves role templates from memory.
+type roleTemplateReader map[string]managementv3.RoleTemplate
+
+func (r roleTemplateReader) Get(_ context.Context, key client.ObjectKey, obj client.Object, _ ...client.GetOption) error {
+	roleTemplate, ok := r[key.Name]
+	if !ok {
+		return apierrors.NewNotFound(schema.GroupResource{Group: "management.cattle.io", Resource: "roletemplates"}, key.Name)
+	}
+	roleTemplate.Name = key.Name
+	*obj.(*managementv3.RoleTemplate) = roleTemplate
+	return nil
+}
+
+func (r roleTemplateReader) List(context.Context, client.ObjectList, ...client.ListOption) error {
+	return nil
+}
+
+func TestRoleTemplateProblem(t *testing.T) {
+	reader := roleTemplateReader{
+		"cluster-owner":   {Context: "cluster"},
+		"read-only":       {Context: "cluster", RoleTemplateNames: []string{"view-nodes", "view-projects"}},
+		"view-nodes":      {Context: "cluster"},
+		"view-projects":   {Context: "cluster", Locked: true},
+		"project-member":  {Context: "project"},
+		"locked-admin":    {Context: "cluster", Locked: true},
+		"mixed":           {Context: "cluster", RoleTemplateNames: []string{"view-nodes", "project-member"}},
+		"dangling":        {Context: "cluster", RoleTemplateNames: []string{"read-only", "gone"}},
+		"cycle-a":         {Context: "cluster", RoleTemplateNames: []string{"cycle-b"}},
+		"cycle-b":         {Context: "cluster", RoleTemplateNames: []string{"cycle-a"}},
+		"project-creator": {Context: "project", RoleTemplateNames: []string{"project-member"}},
+	}
+	tests := []struct {
+		name        string
+		roleContext string
+		want        string
+	}{
+		{name: "cluster-owner", roleContext: clusterRoleContext, want: ""},
+		{name: "read-only", roleContext: clusterRoleContext, want: ""},
+		{name: "project-creator", roleContext: projectRoleContext, want: ""},
+		{name: "missing", roleContext: clusterRoleContext, want: `role template "missing" not found`},
+		{name: "locked-admin", roleContext: clusterRoleContext, want: `role template "locked-admin" is locked`},
+		{name: "project-member", roleContext: clusterRoleContext, want: `role template "project-member" has context "project", not "cluster"`},
+		{name: "mixed", roleContext: clusterRoleContext, want: `role template "project-member", inherited through mixed > project-member, has context "project", not "cluster"`},
+		{name: "dangling", roleContext: clusterRoleContext, want: `role template "gone", inherited through dangling > gone, not found`},
+		{name: "cycle-a", roleContext: clusterRoleContext, want: "role templates inherit from each other in a cycle: cycle-a > cycle-b > cycle-a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := roleTemplateProblem(context.Background(), reader, tt.name, tt.roleContext)
+			if err != nil {
+				t.Fatalf("roleTemplateProblem() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("roleTemplateProblem() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	problems, failures, err := checkAssignmentRoleTemplates(context.Background(), reader, []string{"cluster-owner", "missing"}, clusterRoleContext)
+	if err != nil {
+		t.Fatalf("checkAssignmentRoleTemplates() error = %v", err)
+	}
+	if len(problems) != 2 || problems[0] != "" || problems[1] == "" || len(failures) != 1 || failures[0] != `roleTemplateNames[1]: role template "missing" not found` {
+		t.Errorf("checkAssignmentRoleTemplates() = %q, %q", problems, failures)
+	}
+}
+
+func TestRoleTemplateValidityChanged(t *testing.T) {
+	base := managementv3.RoleTemplate{Context: clusterRoleContext, RoleTemplateNames: []string{"cluster-member"}}
+	tests := []struct {
+		name   string
+		update func(*managementv3.RoleTemplate)
+		want   bool
+	}{
+		{name: "context", update: func(rt *managementv3.RoleTemplate) { rt.Context = projectRoleContext }, want: true},
+		{name: "locked", update: func(rt *managementv3.RoleTemplate) { rt.Locked = true }, want: true},
+		{name: "inherited", update: func(rt *managementv3.RoleTemplate) { rt.RoleTemplateNames = nil }, want: true},
+		{name: "description", update: func(rt *managementv3.RoleTemplate) { rt.Description = "Members" }, want: false},
+		{name: "labels", update: func(rt *managementv3.RoleTemplate) { rt.Labels = map[string]string{"team": "platform"} }, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := base.DeepCopy()
+			tt.update(updated)
+			if got := roleTemplateValidityChanged.Update(event.UpdateEvent{ObjectOld: base.DeepCopy(), ObjectNew: updated}); got != tt.want {
+				t.Errorf("Update() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
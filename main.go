@@ -17,9 +17,12 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	managementv3 "github.com/rancher/rancher/pkg/apis/management.cattle.io/v3"
+	provisioningv1 "github.com/rancher/rancher/pkg/apis/provisioning.cattle.io/v1"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -47,6 +50,7 @@ func init() {
 
 	utilruntime.Must(permissionsv1alpha1.AddToScheme(scheme))
 	utilruntime.Must(managementv3.AddToScheme(scheme))
+	utilruntime.Must(provisioningv1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -54,11 +58,18 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var roleTemplatesFile string
+	var principalProviders string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&roleTemplatesFile, "role-templates-file", "/config/roleTemplates.json",
+		"The role mapping rules used while no RoleMapping exists, in the RoleMappingConfig format (YAML or JSON).")
+	flag.StringVar(&principalProviders, "principal-provider-preference", "",
+		"Comma-separated auth providers, e.g. azuread,local, whose principal ID goes into the bindings of users with several, "+
+			"most preferred first. Users without a principal of these providers get their first principal ID.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -91,13 +102,69 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err = controllers.IndexFields(context.Background(), mgr.GetFieldIndexer()); err != nil {
+		setupLog.Error(err, "unable to register field indexes")
+		os.Exit(1)
+	}
+	roleTemplates := &controllers.RoleTemplatesFile{
+		Path:   roleTemplatesFile,
+		Reader: mgr.GetAPIReader(),
+	}
+	if err = (&controllers.UserReconciler{
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		Recorder:           mgr.GetEventRecorderFor("rancher-operator-permissions"),
+		RoleTemplatesFile:  roleTemplates,
+		PrincipalProviders: splitList(principalProviders),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "User")
+		os.Exit(1)
+	}
+	if err = mgr.Add(roleTemplates); err != nil {
+		setupLog.Error(err, "unable to watch role templates file")
+		os.Exit(1)
+	}
 	if err = (&controllers.ClusterAssignmentReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		Recorder:           mgr.GetEventRecorderFor("rancher-operator-permissions"),
+		PrincipalProviders: splitList(principalProviders),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ClusterAssignment")
 		os.Exit(1)
 	}
+	if err = (&controllers.ProjectAssignmentReconciler{
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		Recorder:           mgr.GetEventRecorderFor("rancher-operator-permissions"),
+		PrincipalProviders: splitList(principalProviders),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ProjectAssignment")
+		os.Exit(1)
+	}
+	if err = (&controllers.GlobalRoleAssignmentReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("rancher-operator-permissions"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "GlobalRoleAssignment")
+		os.Exit(1)
+	}
+	if err = (&controllers.RoleMappingReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("rancher-operator-permissions"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "RoleMapping")
+		os.Exit(1)
+	}
+	if err = mgr.Add(&controllers.OrphanedBindingsSweep{
+		Client: mgr.GetClient(),
+		Reader: mgr.GetAPIReader(),
+	}); err != nil {
+		setupLog.Error(err, "unable to set up orphaned bindings sweep")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -108,6 +175,10 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("role-templates-file", roleTemplates.Check); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -115,3 +186,14 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}